@@ -0,0 +1,44 @@
+// Package atomicfile writes files through temporary file and rename, so
+// readers and failed writes never see partial file
+package atomicfile
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Perm permission of written files
+const Perm = 0644
+
+// Write write fn with write into temporary file in the same directory
+// and rename it to fn when write succeeds
+func Write(fn string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(fn), "."+filepath.Base(fn)+".*")
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Chmod(Perm)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// WriteFile write b to fn
+func WriteFile(fn string, b []byte) error {
+	return Write(fn, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
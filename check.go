@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prgra/rkndaemon/daemon"
+)
+
+// checkCmd check running daemon with /readyz or /healthz, without http
+// listener checks saved dump date, error exits non-zero
+func checkCmd(args []string) error {
+	var cfg daemon.Config
+	err := cfg.LoadLocal()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	live := fs.Bool("live", false, "check liveness (/healthz) instead of readiness (/readyz)")
+	addr := fs.String("url", "", "health url, default from listen")
+	timeout := fs.Duration("timeout", 5*time.Second, "request timeout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: rkndaemon check [-live] [-url http://127.0.0.1:8080/readyz]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint
+	u := *addr
+	if u == "" && cfg.ListerHTTP == "" {
+		err = cfg.CheckLocal(time.Now())
+		if err != nil {
+			return fmt.Errorf("not ready: %w", err)
+		}
+		fmt.Println("ok")
+		return nil
+	}
+	if u == "" {
+		u, err = daemon.HealthURL(cfg.ListerHTTP, *live)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("daemon is not responding: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	fmt.Print(string(body))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+// dumpbench compares peak memory of legacy in-memory and streaming
+// processing of getResult answer on synthetic dump
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/prgra/rkndaemon/daemon"
+	"github.com/prgra/rkndaemon/downloader"
+)
+
+func main() {
+	records := flag.Int("records", 300000, "records in synthetic dump")
+	dir := flag.String("dir", filepath.Join(os.TempDir(), "dumpbench"), "directory for synthetic answer and outputs")
+	mode := flag.String("mode", "", "run one mode: legacy or stream, both in child processes if empty")
+	parse := flag.Bool("parse", true, "parse xml after download")
+	flag.Parse()
+	log.SetOutput(io.Discard)
+	answer := filepath.Join(*dir, fmt.Sprintf("answer-%d.xml", *records))
+	var err error
+	switch *mode {
+	case "":
+		err = compare(answer, *records, *dir, *parse)
+	case "legacy":
+		err = legacy(answer, *dir, *parse)
+	case "stream":
+		err = stream(answer, *dir, *parse)
+	default:
+		err = fmt.Errorf("unknown mode %s", *mode)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// compare run both modes in fresh processes, so peak rss of one does
+// not hide the other
+func compare(answer string, records int, dir string, parse bool) error {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(answer); err != nil {
+		fmt.Println("generate", answer)
+		err = generate(answer, records)
+		if err != nil {
+			return err
+		}
+	}
+	fi, err := os.Stat(answer)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("answer %s, %d records, parse %v\n", downloader.ByteCountIEC(fi.Size()), records, parse)
+	for _, m := range []string{"legacy", "stream"} {
+		cmd := exec.Command(os.Args[0], "-mode", m, "-dir", dir, fmt.Sprintf("-records=%d", records), fmt.Sprintf("-parse=%v", parse)) // nolint
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		err = cmd.Run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacy keep answer, soap body, base64 text and zip in memory as
+// gosoap Call, Unmarshal and DecodeString did
+func legacy(answer string, dir string, parse bool) error {
+	start := time.Now()
+	b, err := os.ReadFile(answer)
+	if err != nil {
+		return err
+	}
+	var env struct {
+		Body struct {
+			Contents []byte `xml:",innerxml"`
+		}
+	}
+	err = xml.Unmarshal(b, &env)
+	if err != nil {
+		return err
+	}
+	var r struct {
+		Zip []byte `xml:"getResultResponse>registerZipArchive"`
+	}
+	err = xml.Unmarshal([]byte("<r>"+string(env.Body.Contents)+"</r>"), &r)
+	if err != nil {
+		return err
+	}
+	z, err := base64.StdEncoding.DecodeString(string(r.Zip))
+	if err != nil {
+		return err
+	}
+	fn, err := extractXML(z, dir)
+	if err != nil {
+		return err
+	}
+	if parse {
+		err = process(dir, func(a *daemon.App) error {
+			return a.ProcessDumpFile(context.Background(), fn, filepath.Join(dir, "output-legacy"))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	report("legacy", start)
+	return nil
+}
+
+// extractXML write xml of in-memory zip into dir as legacy download did
+func extractXML(z []byte, dir string) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(z), int64(len(z)))
+	if err != nil {
+		return "", err
+	}
+	for _, zf := range zr.File {
+		if filepath.Ext(zf.Name) != ".xml" {
+			continue
+		}
+		fn := filepath.Join(dir, filepath.Base(zf.Name))
+		r, err := zf.Open()
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		f, err := os.Create(fn)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(f, r)
+		if err != nil {
+			f.Close()
+			return "", err
+		}
+		return fn, f.Close()
+	}
+	return "", fmt.Errorf("no xml file in zip")
+}
+
+// stream decode answer into zip file and parse xml from zip
+func stream(answer string, dir string, parse bool) error {
+	start := time.Now()
+	f, err := os.Open(answer)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zfn := filepath.Join(dir, "dump.zip")
+	zf, err := os.Create(zfn)
+	if err != nil {
+		return err
+	}
+	_, err = downloader.StreamZip(f, zf)
+	if err != nil {
+		zf.Close()
+		return err
+	}
+	err = zf.Close()
+	if err != nil {
+		return err
+	}
+	xr, _, err := downloader.OpenXMLInZip(zfn)
+	if err != nil {
+		return err
+	}
+	defer xr.Close()
+	if parse {
+		err = process(dir, func(a *daemon.App) error {
+			return a.ProcessDump(context.Background(), xr, filepath.Join(dir, "output-stream"))
+		})
+	} else {
+		_, err = io.Copy(io.Discard, xr)
+	}
+	if err != nil {
+		return err
+	}
+	report("stream", start)
+	return nil
+}
+
+func process(dir string, f func(a *daemon.App) error) error {
+	a, err := daemon.NewOffline(daemon.Config{StateDir: dir, OutputDir: dir})
+	if err != nil {
+		return err
+	}
+	return f(a)
+}
+
+// report print peak rss of process, ru_maxrss is in kilobytes
+func report(mode string, start time.Time) {
+	var ru syscall.Rusage
+	err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	if err != nil {
+		fmt.Println(mode, err)
+		return
+	}
+	fmt.Printf("%-6s peak rss %s, time %s\n", mode, downloader.ByteCountIEC(int64(ru.Maxrss)*1024), time.Since(start).Truncate(time.Millisecond))
+}
+
+// generate write getResult soap answer with synthetic dump of n records,
+// random parts keep archive from compressing too well
+func generate(fn string, n int) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>`+
+		`<ns2:getResultResponse xmlns:ns2="http://vigruzki.rkn.gov.ru/OperatorRequest/"><result>true</result><resultCode>0</resultCode><registerZipArchive>`)
+	b64 := base64.NewEncoder(base64.StdEncoding, w)
+	zw := zip.NewWriter(b64)
+	xw, err := zw.Create("dump.xml")
+	if err != nil {
+		return err
+	}
+	xb := bufio.NewWriter(xw)
+	rnd := rand.New(rand.NewSource(1))
+	fmt.Fprint(xb, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<reg:register updateTime="2024-01-01T00:00:00+03:00" formatVersion="2.4" xmlns:reg="http://rsoc.ru" xmlns:tns="http://rsoc.ru">`+"\n")
+	var buf bytes.Buffer
+	for i := 1; i <= n; i++ {
+		buf.Reset()
+		host := fmt.Sprintf("h%x-%d.example", rnd.Int63(), i)
+		fmt.Fprintf(&buf, `<content id="%d" includeTime="2024-01-01T00:00:00" entryType="1" blockType="default" hash="%032x">`, i, rnd.Int63())
+		fmt.Fprintf(&buf, `<decision date="2024-01-01" number="%d" org="test"/>`, rnd.Intn(1000000))
+		fmt.Fprintf(&buf, `<url><![CDATA[https://%s/%x]]></url><domain><![CDATA[%s]]></domain>`, host, rnd.Int63(), host)
+		fmt.Fprintf(&buf, `<ip>%d.%d.%d.%d</ip></content>`+"\n", 1+rnd.Intn(222), rnd.Intn(256), rnd.Intn(256), 1+rnd.Intn(254))
+		xb.Write(buf.Bytes()) // nolint
+	}
+	fmt.Fprint(xb, "</reg:register>\n")
+	err = xb.Flush()
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	err = b64.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(w, `</registerZipArchive></ns2:getResultResponse></soap:Body></soap:Envelope>`)
+	return w.Flush()
+}
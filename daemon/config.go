@@ -0,0 +1,275 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cristalhq/aconfig"
+	"github.com/cristalhq/aconfig/aconfigtoml"
+	"github.com/prgra/rkndaemon/downloader"
+	"github.com/prgra/rkndaemon/resolver"
+)
+
+// configFiles searched in order, first found is used
+var configFiles = []string{
+	"rkndaemon.toml",
+	"/etc/rkndaemon.toml",
+}
+
+// Config for application
+type Config struct {
+	URL            string    `default:"https://vigruzki2.rkn.gov.ru/services/OperatorRequest2/?wsdl" toml:"rknurl" env:"URL"`
+	User           string    `toml:"rknuser" env:"USER"`
+	Pass           string    `toml:"rknpass" env:"PASS"`
+	DNSServers     []string  `default:"8.8.8.8,1.1.1.1" toml:"dnses" env:"DNSSERVERS"`
+	WorkerCount    int       `default:"64" toml:"dnsworkers" env:"WORKERCOUNT"`
+	DNSTimeout     int       `default:"5000" toml:"dnstimeout" env:"DNSTIMEOUT"`
+	DNSServerQPS   int       `default:"0" toml:"dnsserverqps" env:"DNSSERVERQPS"`
+	DNSRetries     int       `default:"3" toml:"dnsretries" env:"DNSRETRIES"`
+	DNSMaxFails    int       `default:"5" toml:"dnsmaxfails" env:"DNSMAXFAILS"`
+	DNSEjectTime   int       `default:"30" toml:"dnseject" env:"DNSEJECT"`
+	SystemResolver bool      `default:"true" toml:"systemresolver" env:"SYSTEMRESOLVER"`
+	IPWhitelist    []string  `toml:"ipwhitelist" env:"IPWHITELIST"`
+	Sinkholes      []string  `toml:"sinkholes" env:"SINKHOLES"`
+	ResolverFile   string    `default:"" toml:"resolvfile" env:"RESOLVERFILE"`
+	ResolverGrace  int       `default:"24" toml:"resolvgrace" env:"RESOLVERGRACE"`
+	ResolverQPS    int       `default:"500" toml:"dnsqps" env:"DNSQPS"`
+	ResolveRefresh int       `default:"360" toml:"resolvrefresh" env:"RESOLVEREFRESH"`
+	SubdomainWords []string  `default:"www,m,api,cdn,static,img,mail,mobile" toml:"subdomains" env:"SUBDOMAINS"`
+	SocialInterval int       `default:"60" toml:"socinterval" env:"SOCIALINTERVAL"`
+	DumpInterval   int       `default:"5" toml:"dumpinterval" env:"DUMPINTERVAL"`
+	RetryBase      int       `default:"30" toml:"retrybase" env:"RETRYBASE"`
+	RetryMax       int       `default:"3600" toml:"retrymax" env:"RETRYMAX"`
+	RetryThreshold int       `default:"5" toml:"retrythreshold" env:"RETRYTHRESHOLD"`
+	StaleWarning   int       `default:"360" toml:"stalewarning" env:"STALEWARNING"`
+	StaleCritical  int       `default:"1440" toml:"stalecritical" env:"STALECRITICAL"`
+	Hooks          []Hook    `toml:"hooks"`
+	Webhooks       []Webhook `toml:"webhooks"`
+	PostScript     string    `toml:"postscript" env:"POSTSCRIPT"`     // deprecated, dump-updated hook
+	SocialScript   string    `toml:"socialscript" env:"SOCIALSCRIPT"` // deprecated, social-updated hook
+	UseDump        bool      `default:"true" toml:"usedump" env:"USEDUMP"`
+	UseSoc         bool      `default:"true" toml:"usesoc" env:"USESOC"`
+	SubtractSocial bool      `default:"false" toml:"subtractsocial" env:"SUBTRACTSOCIAL"`
+	UseResolver    bool      `default:"false" toml:"useresolver" env:"USERESOLVER"`
+	Cron           bool      `dafault:"false" toml:"cron" ENV:"CRON"`
+	ListerHTTP     string    `default:"" toml:"listen" ENV:"LISTEN"`
+	HTTPToken      string    `default:"" toml:"httptoken" ENV:"HTTPTOKEN"`
+	WatchConfig    int       `default:"10" toml:"watchconfig" env:"WATCHCONFIG"`
+	StateDir       string    `default:"state" toml:"statedir" env:"STATEDIR"`
+	SaveXML        bool      `default:"true" toml:"savexml" env:"SAVEXML"`
+	OutputDir      string    `default:"output" toml:"outputdir" env:"OUTPUTDIR"`
+	UseArchive     bool      `default:"true" toml:"usearchive" env:"USEARCHIVE"`
+	ArchiveCount   int       `default:"500" toml:"archivecount" env:"ARCHIVECOUNT"`
+	ArchiveDays    int       `default:"90" toml:"archivedays" env:"ARCHIVEDAYS"`
+	ArchiveSizeMB  int64     `default:"0" toml:"archivesize" env:"ARCHIVESIZE"`
+}
+
+// Load configuration
+func (c *Config) Load() error {
+	err := c.LoadLocal()
+	if err != nil {
+		return err
+	}
+	err = c.Validate()
+	if err != nil {
+		return err
+	}
+	preu, _ := url.Parse(c.URL)
+	u, _ := url.Parse(fmt.Sprintf("%s://%s:%s@%s%s?%s", preu.Scheme, c.User, c.Pass, preu.Host, preu.Path, preu.RawQuery))
+	c.URL = u.String()
+	return nil
+}
+
+// LoadLocal load configuration without validation, enough for
+// local processing without RKN credentials
+func (c *Config) LoadLocal() error {
+	loader := aconfig.LoaderFor(c, aconfig.Config{
+		SkipFlags: true,
+		EnvPrefix: "RKN",
+		Files:     configFiles,
+		FileDecoders: map[string]aconfig.FileDecoder{
+			".toml": tomlDecoder{aconfigtoml.New()},
+		},
+	})
+	err := loader.Load()
+	if err != nil {
+		return err
+	}
+	return c.resolvePaths(ConfigFile())
+}
+
+// resolvePaths make relative statedir, outputdir and resolvfile absolute
+// against directory of config file cfn, or working directory without
+// config file, so they don't depend on where daemon is started from
+func (c *Config) resolvePaths(cfn string) error {
+	base, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if cfn != "" {
+		cfn, err = filepath.Abs(cfn)
+		if err != nil {
+			return err
+		}
+		base = filepath.Dir(cfn)
+	}
+	for _, p := range []*string{&c.StateDir, &c.OutputDir, &c.ResolverFile} {
+		if *p == "" || filepath.IsAbs(*p) {
+			continue
+		}
+		old, _ := filepath.Abs(*p)
+		*p = filepath.Join(base, *p)
+		if _, err := os.Stat(*p); os.IsNotExist(err) && old != *p {
+			if _, err := os.Stat(old); err == nil {
+				log.Println(old, "is not used,", *p, "relative to", base, "is used instead")
+			}
+		}
+	}
+	return nil
+}
+
+// tomlDecoder converts arrays of tables ([[hooks]]) to the form
+// aconfig expects for slices of structs
+type tomlDecoder struct {
+	*aconfigtoml.Decoder
+}
+
+// DecodeFile decode toml file
+func (d tomlDecoder) DecodeFile(fn string) (map[string]interface{}, error) {
+	m, err := d.Decoder.DecodeFile(fn)
+	for k, v := range m {
+		if l, ok := v.([]map[string]interface{}); ok {
+			res := make([]interface{}, len(l))
+			for i := range l {
+				res[i] = l[i]
+			}
+			m[k] = res
+		}
+	}
+	return m, err
+}
+
+// Validate check configuration values
+func (c *Config) Validate() error {
+	if c.User == "" || c.Pass == "" {
+		return fmt.Errorf("need user and password params")
+	}
+	_, err := url.Parse(c.URL)
+	if err != nil {
+		return err
+	}
+	if c.UseResolver && len(c.DNSServers) == 0 {
+		return fmt.Errorf("need at least one dns server")
+	}
+	for _, s := range c.DNSServers {
+		_, err = resolver.ParseUpstream(s, 0)
+		if err != nil {
+			return fmt.Errorf("dns server %s: %w", s, err)
+		}
+	}
+	if c.WorkerCount < 1 {
+		return fmt.Errorf("dnsworkers must be positive, got %d", c.WorkerCount)
+	}
+	_, err = resolver.NewFilter(c.IPWhitelist, c.Sinkholes)
+	if err != nil {
+		return fmt.Errorf("ipwhitelist or sinkholes: %w", err)
+	}
+	if c.DNSTimeout < 1 || c.DNSRetries < 0 || c.DNSServerQPS < 0 {
+		return fmt.Errorf("dnstimeout must be positive, dnsretries and dnsserverqps can't be negative")
+	}
+	if c.StateDir == "" || c.OutputDir == "" {
+		return fmt.Errorf("statedir and outputdir can't be empty")
+	}
+	if c.DumpInterval < 1 || c.SocialInterval < 1 {
+		return fmt.Errorf("dumpinterval and socinterval must be positive")
+	}
+	if c.RetryBase < 1 || c.RetryMax < c.RetryBase || c.RetryThreshold < 1 {
+		return fmt.Errorf("retrybase and retrythreshold must be positive, retrymax not less than retrybase")
+	}
+	if c.StaleWarning < 0 || c.StaleCritical < 0 {
+		return fmt.Errorf("stalewarning and stalecritical can't be negative")
+	}
+	if c.StaleWarning > 0 && c.StaleCritical > 0 && c.StaleCritical < c.StaleWarning {
+		return fmt.Errorf("stalecritical must be greater than stalewarning")
+	}
+	for _, h := range c.hooks() {
+		err = h.validate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, w := range c.Webhooks {
+		err = w.validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolverFile returns ResolverFile or resolved.txt in output dir
+func (c *Config) resolverFile() string {
+	if c.ResolverFile != "" {
+		return c.ResolverFile
+	}
+	return filepath.Join(c.OutputDir, "resolved.txt")
+}
+
+// poolOptions returns options of resolver upstream pool
+func (c *Config) poolOptions() resolver.PoolOptions {
+	return resolver.PoolOptions{
+		Timeout:   time.Duration(c.DNSTimeout) * time.Millisecond,
+		QPS:       c.DNSServerQPS,
+		Retries:   c.DNSRetries,
+		MaxFails:  c.DNSMaxFails,
+		EjectTime: time.Duration(c.DNSEjectTime) * time.Second,
+	}
+}
+
+// retry returns retry policy of download loops
+func (c *Config) retry() *downloader.Retry {
+	r := downloader.NewRetry(0, 0, 0)
+	c.configureRetry(r)
+	return r
+}
+
+// configureRetry apply retry settings to r
+func (c *Config) configureRetry(r *downloader.Retry) {
+	r.Configure(time.Duration(c.RetryBase)*time.Second, time.Duration(c.RetryMax)*time.Second, c.RetryThreshold)
+}
+
+// archive returns archive in state dir, nil if disabled
+func (c *Config) archive(st *downloader.State) *downloader.Archive {
+	if !c.UseArchive {
+		return nil
+	}
+	return &downloader.Archive{
+		Dir:      st.ArchiveDir(),
+		MaxCount: c.ArchiveCount,
+		MaxAge:   time.Duration(c.ArchiveDays) * 24 * time.Hour,
+		MaxSize:  c.ArchiveSizeMB << 20,
+	}
+}
+
+// resolveRefresh returns age after which cached answers are re-resolved
+func (c *Config) resolveRefresh() time.Duration {
+	if c.ResolveRefresh < 1 {
+		return time.Minute
+	}
+	return time.Duration(c.ResolveRefresh) * time.Minute
+}
+
+// ConfigFile returns path of used config file or empty string
+func ConfigFile() string {
+	for _, fn := range configFiles {
+		_, err := os.Stat(fn)
+		if err == nil {
+			return fn
+		}
+	}
+	return ""
+}
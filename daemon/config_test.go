@@ -0,0 +1,40 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePaths(t *testing.T) {
+	dir := t.TempDir()
+	cfn := filepath.Join(dir, "etc", "rkndaemon.toml")
+	c := Config{StateDir: "state", OutputDir: "/srv/rkn/output", ResolverFile: "../out/resolved.txt"}
+	err := c.resolvePaths(cfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{
+		StateDir:     filepath.Join(dir, "etc", "state"),
+		OutputDir:    "/srv/rkn/output",
+		ResolverFile: filepath.Join(dir, "out", "resolved.txt"),
+	}
+	if c.StateDir != want.StateDir || c.OutputDir != want.OutputDir || c.ResolverFile != want.ResolverFile {
+		t.Errorf("paths %q %q %q, want %q %q %q", c.StateDir, c.OutputDir, c.ResolverFile,
+			want.StateDir, want.OutputDir, want.ResolverFile)
+	}
+
+	// without config file paths are fixed against working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = Config{StateDir: "state", OutputDir: "output"}
+	err = c.resolvePaths("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.StateDir != filepath.Join(wd, "state") || c.OutputDir != filepath.Join(wd, "output") || c.ResolverFile != "" {
+		t.Errorf("paths without config %q %q %q", c.StateDir, c.OutputDir, c.ResolverFile)
+	}
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/prgra/rkndaemon/metrics"
+	"github.com/prgra/rkndaemon/parser"
+)
+
+func init() {
+	metrics.Describe("rkndaemon_register_conflicts", "gauge", "blocked entries hitting social resources by kind")
+}
+
+// checkConflicts find blocked entries hitting social resources and
+// write them to conflicts.json in dir
+func (a *App) checkConflicts(dir string) {
+	a.dbMu.RLock()
+	l := a.Parser.Conflicts()
+	a.dbMu.RUnlock()
+	kinds := map[string]int{"ip": 0, "subnet": 0, "domain": 0, "mask": 0}
+	for _, c := range l {
+		kinds[c.Kind]++
+	}
+	for k, n := range kinds {
+		metrics.Set("rkndaemon_register_conflicts", float64(n), "kind", k)
+	}
+	if len(l) > 0 {
+		log.Printf("found %d conflicts between blocking and social registers, see %s", len(l), parser.ConflictsFile)
+	}
+	err := parser.WriteConflicts(filepath.Join(dir, parser.ConflictsFile), l)
+	if err != nil {
+		log.Println("can't write conflicts", err)
+	}
+	a.mu.Lock()
+	a.conflicts = len(l)
+	a.mu.Unlock()
+}
+
+// loadSocial load social register written by previous run, so conflicts
+// and subtraction work before first social download
+func (a *App) loadSocial(dir string) {
+	recs, err := parser.LoadSocRecords(filepath.Join(dir, parser.SocRecordsFile))
+	if err != nil {
+		log.Println("can't load social records", err)
+		return
+	}
+	a.dbMu.Lock()
+	defer a.dbMu.Unlock()
+	for _, r := range recs {
+		a.Parser.ParseSoc(r)
+	}
+}
+
+// socialNets returns social subnets to keep out of resolver results
+func (a *App) socialNets() []string {
+	a.dbMu.RLock()
+	defer a.dbMu.RUnlock()
+	res := make([]string, 0, len(a.Parser.SocNets))
+	for k := range a.Parser.SocNets {
+		res = append(res, k)
+	}
+	return res
+}
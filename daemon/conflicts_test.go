@@ -0,0 +1,60 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prgra/rkndaemon/parser"
+)
+
+func TestConflictsNeedBothRegisters(t *testing.T) {
+	prev := []byte(`[{"kind":"ip"}]`)
+	for _, dumpFirst := range []bool{true, false} {
+		dir := t.TempDir()
+		cfn := filepath.Join(dir, parser.ConflictsFile)
+		err := os.WriteFile(cfn, prev, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := NewOffline(Config{OutputDir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 10.0.1.0 of record 256 is in social subnet 10.0.1.0/24
+		dump := func() {
+			err := a.ProcessDump(context.Background(), bytes.NewReader(dumpXML(300)), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		social := func() {
+			_, err := a.ProcessSocial(bytes.NewReader(socialXML(10)), "social.xml", dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		first, second := social, dump
+		if dumpFirst {
+			first, second = dump, social
+		}
+		first()
+		b, _ := os.ReadFile(cfn)
+		if !bytes.Equal(b, prev) {
+			t.Fatalf("dump first %v: conflicts.json replaced with one register: %s", dumpFirst, b)
+		}
+		second()
+		b, _ = os.ReadFile(cfn)
+		var l []parser.Conflict
+		err = json.Unmarshal(b, &l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l) == 0 {
+			t.Errorf("dump first %v: no conflicts with both registers", dumpFirst)
+		}
+	}
+}
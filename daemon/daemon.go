@@ -2,139 +2,234 @@
 package daemon
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/cristalhq/aconfig"
-	"github.com/cristalhq/aconfig/aconfigtoml"
 	"github.com/prgra/rkndaemon/downloader"
+	"github.com/prgra/rkndaemon/metrics"
 	"github.com/prgra/rkndaemon/parser"
 	"github.com/prgra/rkndaemon/resolver"
 
-	"github.com/tiaguinho/gosoap"
 	"golang.org/x/text/encoding/charmap"
 )
 
 // App main application
 type App struct {
-	Downloader *downloader.Downloader
-	Resolver   *resolver.Resolver
-	Parser     *parser.DB
-	Config     Config
-	waitGroup  *sync.WaitGroup
+	Downloader    *downloader.Downloader
+	Resolver      *resolver.Resolver
+	ResolverCache *resolver.Cache
+	Parser        *parser.DB
+	State         *downloader.State
+	Archive       *downloader.Archive
+	Config        Config
+	waitGroup     *sync.WaitGroup
+	webhookWG     sync.WaitGroup
+	mu            sync.RWMutex
+	dbMu          sync.RWMutex // guards Parser against http readers
+	resolveMu     sync.Mutex
+	pool          *resolver.Pool // kept between resolver runs, guarded by mu
+	poolServers   []string
+	poolOpts      resolver.PoolOptions
+	conflicts     int                    // count of last found conflicts, guarded by mu
+	dumpChanges   parser.DumpDiff        // entries added and removed by last dump, guarded by mu
+	dumpLoaded    bool                   // lists of a dump are read in this process, guarded by mu
+	dumpDiff      bool                   // dumpChanges are relative to previous dump
+	dumpReady     bool                   // dump is applied or restored in this process, guarded by mu
+	socDiff       parser.SocDiff         // last social changes, guarded by mu
+	social        downloader.SocialState // guarded by mu
+	dumpRetry     *downloader.Retry
+	socRetry      *downloader.Retry
+	socFailure    *SocialFailure  // last failed social cycle, guarded by mu
+	watch         DumpWatch       // guarded by mu
+	workers       map[string]bool // running background workers, guarded by mu
+	stopping      bool
+	dumpNow       chan struct{}
+	socNow        chan struct{}
+	reloaded      chan struct{}
 }
 
-// Config for application
-type Config struct {
-	URL            string   `default:"https://vigruzki2.rkn.gov.ru/services/OperatorRequest2/?wsdl" toml:"rknurl" env:"URL"`
-	User           string   `toml:"rknuser" env:"USER"`
-	Pass           string   `toml:"rknpass" env:"PASS"`
-	DNSServers     []string `default:"[8.8.8.8],[1.1.1.1]" toml:"dnses" env:"DNSSERVERS"`
-	WorkerCount    int      `default:"64" toml:"dnsworkers" env:"WORKERCOUNT"`
-	ResolverFile   string   `default:"output/resolved.txt" toml:"resolvfile" env:"RESOLVERFILE"`
-	SocialInterval int      `default:"60" toml:"socinterval" env:"SOCIALINTERVAL"`
-	DumpInterval   int      `default:"5" toml:"dumpinterval" env:"DUMPINTERVAL"`
-	PostScript     string   `toml:"postscript" env:"POSTSCRIPT"`
-	SocialScript   string   `toml:"socialscript" env:"SOCIALSCRIPT"`
-	UseDump        bool     `default:"true" toml:"usedump" env:"USEDUMP"`
-	UseSoc         bool     `default:"true" toml:"usesoc" env:"USESOC"`
-	UseResolver    bool     `default:"false" toml:"useresolver" env:"USERESOLVER"`
-	Cron           bool     `dafault:"false" toml:"cron" ENV:"CRON"`
-	ListerHTTP     string   `default:"" toml:"listen" ENV:"LISTEN"`
-	HTTPToken      string   `default:"" toml:"httptoken" ENV:"HTTPTOKEN"`
+func init() {
+	metrics.Describe("rkndaemon_social_last_checked_timestamp_seconds", "gauge", "time of last social register download")
+	metrics.Describe("rkndaemon_social_last_changed_timestamp_seconds", "gauge", "time of last social register change")
+	metrics.Describe("rkndaemon_download_errors_total", "counter", "failed download cycles by source and error kind")
+	metrics.Describe("rkndaemon_download_circuit_state", "gauge", "download circuit, 0 closed, 1 half-open, 2 open")
 }
 
-// Load configuration
-func (c *Config) Load() error {
-	loader := aconfig.LoaderFor(c, aconfig.Config{
-		SkipFlags: true,
-		EnvPrefix: "RKN",
-		Files: []string{
-			"rkndaemon.toml",
-			"/etc/rkndaemon.toml",
-		},
-		FileDecoders: map[string]aconfig.FileDecoder{
-			".toml": aconfigtoml.New(),
-		},
-	})
-	err := loader.Load()
+// New create new application
+func New(c Config) (a *App, err error) {
+	dwn, err := downloader.New(c.URL)
 	if err != nil {
-		return err
-	}
-	if c.User == "" || c.Pass == "" {
-		return fmt.Errorf("need user and password params")
+		return a, err
 	}
-	preu, err := url.Parse(c.URL)
+	st, err := downloader.NewState(c.StateDir)
 	if err != nil {
-		return err
+		return a, err
 	}
-	u, _ := url.Parse(fmt.Sprintf("%s://%s:%s@%s%s?%s", preu.Scheme, c.User, c.Pass, preu.Host, preu.Path, preu.RawQuery))
-	c.URL = u.String()
-	return nil
-}
-
-// New create new application
-func New(c Config) (a *App, err error) {
-	dwn, err := downloader.New(c.URL)
+	a, err = NewOffline(c)
 	if err != nil {
 		return a, err
 	}
+	a.State = st
+	err = a.State.MigrateLegacy()
+	if err != nil {
+		log.Println("can't migrate legacy state", err)
+	}
+	a.ResolverCache, err = resolver.LoadCache(st.ResolverCacheFile())
+	if err != nil {
+		log.Println("can't load resolver cache, start empty", err)
+	}
+	if c.UseDump {
+		dd, _ := st.LoadDumpDate()
+		a.initWatch(dd)
+	}
+	if c.UseSoc {
+		a.loadSocial(c.OutputDir)
+		a.social, err = st.LoadSocial()
+		if err != nil {
+			log.Println("can't load social state", err)
+		}
+	}
+	a.Downloader = dwn
+	return a, nil
+}
+
+// NewOffline create application without downloader and state directories,
+// for local processing
+func NewOffline(c Config) (a *App, err error) {
+	st := &downloader.State{Dir: filepath.Clean(c.StateDir)}
 	var wg sync.WaitGroup
-	res := resolver.New(c.DNSServers)
-	res.Run(c.WorkerCount, c.ResolverFile)
 	return &App{
-		Downloader: dwn,
-		Resolver:   res,
-		Parser:     parser.NewDB(),
-		Config:     c,
-		waitGroup:  &wg,
+		Parser:    parser.NewDB(),
+		State:     st,
+		Archive:   c.archive(st),
+		Config:    c,
+		dumpRetry: c.retry(),
+		socRetry:  c.retry(),
+		waitGroup: &wg,
+		dumpNow:   make(chan struct{}, 1),
+		socNow:    make(chan struct{}, 1),
+		reloaded:  make(chan struct{}),
 	}, nil
 }
 
-// Run application
-func (a *App) Run() {
-	if a.Config.UseDump {
+// Run application, returns when downloaders finished or ctx canceled
+func (a *App) Run(ctx context.Context) {
+	cfg := a.config()
+	if cfg.UseDump {
 		a.waitGroup.Add(1)
-		go a.DumpDownloader(time.Duration(a.Config.DumpInterval) * time.Minute)
+		go a.DumpDownloader(ctx)
 	}
-	if a.Config.UseSoc {
+	if cfg.UseSoc {
 		a.waitGroup.Add(1)
-		go a.SocialDownloader(time.Duration(a.Config.SocialInterval) * time.Minute)
+		go a.SocialDownloader(ctx)
+	}
+	if cfg.UseDump && !cfg.Cron {
+		a.waitGroup.Add(1)
+		go a.Watchdog(ctx)
+	}
+	if !cfg.Cron && (cfg.UseDump || cfg.UseSoc) {
+		a.waitGroup.Add(1)
+		go a.ResolveRefresher(ctx)
+	}
+	if cfg.WatchConfig > 0 && !cfg.Cron {
+		go a.WatchConfig(ctx, time.Duration(cfg.WatchConfig)*time.Second)
 	}
 
-	if a.Config.ListerHTTP != "" && !a.Config.Cron {
+	var srv *http.Server
+	if cfg.ListerHTTP != "" && !cfg.Cron {
+		mux := http.NewServeMux()
+		mux.Handle("/", http.FileServer(http.Dir(cfg.OutputDir)))
+		mux.HandleFunc("/api/lookup", a.LookupHandler)
+		mux.HandleFunc("/api/status", a.StatusHandler)
+		mux.Handle("/metrics", metrics.Default)
+		// probes of systemd, balancers and containers go without token
+		root := http.NewServeMux()
+		root.HandleFunc("/healthz", a.HealthzHandler)
+		root.HandleFunc("/readyz", a.ReadyzHandler)
+		root.Handle("/", a.AuthMiddleware(mux))
+		srv = &http.Server{
+			Addr:    cfg.ListerHTTP,
+			Handler: root,
+		}
 		go func() {
-			log.Println("start http server on")
-			err := http.ListenAndServe(a.Config.ListerHTTP, a.AuthMiddleware(http.FileServer(http.Dir("output")))) // nolint
-			if err != nil {
+			log.Println("start http server on", cfg.ListerHTTP)
+			err := srv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
 				log.Fatalf("can't listen http %v", err)
 			}
 		}()
 	}
+	if !cfg.Cron {
+		go a.supervise(ctx)
+	}
 	a.waitGroup.Wait()
+	a.webhookWG.Wait()
+	if srv != nil {
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := srv.Shutdown(sctx)
+		if err != nil {
+			log.Println("http shutdown", err)
+		}
+	}
+	log.Println("daemon stopped")
 }
 
-// ReadDumpFile read dump file and parse it
-func (a *App) ReadDumpFile(fn string) error {
-	log.Println("start read dumpfile")
+// Refresh force immediate dump and social check
+func (a *App) Refresh() {
+	for _, ch := range []chan struct{}{a.dumpNow, a.socNow} {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (a *App) config() Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Config
+}
+
+func (a *App) archive() *downloader.Archive {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Archive
+}
+
+func (a *App) downloader() *downloader.Downloader {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Downloader
+}
+
+// ReadDumpFile read dump file and parse it, previous lists are replaced
+// only when file is read completely
+func (a *App) ReadDumpFile(ctx context.Context, fn string) error {
 	xmlFile, err := os.Open(path.Clean(fn))
 	if err != nil {
 		return err
 	}
 	defer xmlFile.Close()
-	xmlDec := xml.NewDecoder(xmlFile)
+	return a.ReadDump(ctx, xmlFile)
+}
+
+// ReadDump parse dump xml from r, previous lists are replaced only when
+// it is read completely
+func (a *App) ReadDump(ctx context.Context, r io.Reader) error {
+	log.Println("start read dumpfile")
+	db := parser.NewDB()
+	xmlDec := xml.NewDecoder(r)
 	xmlDec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
 		switch charset {
 		case "windows-1251":
@@ -145,259 +240,446 @@ func (a *App) ReadDumpFile(fn string) error {
 	}
 	for {
 		t, err := xmlDec.Token()
-
-		if t == nil {
+		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			// broken stream must not look like end of dump
 			return err
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		switch se := t.(type) {
 		case xml.StartElement:
+			if se.Name.Local != "content" {
+				continue
+			}
 			var item parser.Content
 			err = xmlDec.DecodeElement(&item, &se)
-			if err != nil &&
-				err.Error() != "expected element type <content> but have <register>" {
-				fmt.Fprintln(os.Stderr, err)
+			if err != nil {
+				return err
 			}
 			for i := range se.Attr {
 				if se.Attr[i].Name.Local == "entryType" {
 					item.EntityType = se.Attr[i].Value
 				}
+				if se.Attr[i].Name.Local == "id" {
+					item.ID, _ = strconv.Atoi(se.Attr[i].Value)
+				}
 				if se.Attr[i].Name.Local == "blockType" {
 					item.BlockType = se.Attr[i].Value
 				}
 			}
-			a.Parser.ParseEl(item)
+			db.ParseEl(item)
 		}
 	}
-	log.Println("end read dumpfile")
+	a.dbMu.Lock()
+	changes := a.Parser.SetDump(db)
+	a.dbMu.Unlock()
+	a.mu.Lock()
+	a.dumpDiff = a.dumpLoaded
+	a.dumpLoaded = true
+	if !a.dumpDiff {
+		// every entry of first dump is added
+		changes = parser.DumpDiff{}
+	}
+	a.dumpChanges = changes
+	a.mu.Unlock()
+	log.Printf("end read dumpfile, added %d, removed %d entries", changes.Added.Len(), changes.Removed.Len())
 
 	return nil
 }
 
-// ReadSocialFile read social file and parse it
-func (a *App) ReadSocialFile(fn string) error {
-	log.Println("start read social")
+// ProcessDumpFile parse dump xml and write outputs to dir
+func (a *App) ProcessDumpFile(ctx context.Context, fn string, dir string) error {
 	xmlFile, err := os.Open(path.Clean(fn))
 	if err != nil {
 		return err
 	}
 	defer xmlFile.Close()
-	xmlDec := xml.NewDecoder(xmlFile)
-	for {
-		t, xerr := xmlDec.Token()
+	return a.ProcessDump(ctx, xmlFile, dir)
+}
 
-		if t == nil {
-			break
-		}
-		if xerr != nil {
-			return xerr
-		}
-		switch se := t.(type) {
-		case xml.StartElement:
-			var item parser.SocRecord
-			err = xmlDec.DecodeElement(&item, &se)
-			if err != nil &&
-				err.Error() != "expected element type <content> but have <registerSocResources>" {
-				fmt.Fprintln(os.Stderr, err)
-			}
-			for i := range se.Attr {
-				// id="2" hash="ffdb3ec46de4883efd3c1ca99d7c0ee0" includeTime="2022-01-26T22:00:00+03:00"
+// ProcessDump parse dump xml from r and write outputs to dir
+func (a *App) ProcessDump(ctx context.Context, r io.Reader, dir string) error {
+	err := a.ReadDump(ctx, r)
+	if err != nil {
+		return fmt.Errorf("ReadDump: %w", downloader.ParseError(err))
+	}
+	err = a.writeDumpFiles(dir)
+	if err != nil {
+		return err
+	}
+	err = a.writeDumpChanges(dir)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.dumpReady = true
+	a.mu.Unlock()
+	a.dbMu.RLock()
+	socParsed := len(a.Parser.SocRecords) > 0
+	a.dbMu.RUnlock()
+	if socParsed {
+		a.checkConflicts(dir)
+	}
+	return nil
+}
 
-				if se.Attr[i].Name.Local == "id" {
-					item.ID, _ = strconv.Atoi(se.Attr[i].Value)
-				}
-				if se.Attr[i].Name.Local == "hash" {
-					item.Hash = se.Attr[i].Value
-				}
-				if se.Attr[i].Name.Local == "includeTime" {
-					item.IncludeTime, _ = time.Parse("2006-01-02T15:04:05-07:00", se.Attr[i].Value)
+// writeDumpFiles write outputs of parsed dump to dir
+func (a *App) writeDumpFiles(dir string) error {
+	a.dbMu.Lock()
+	a.Parser.SubtractSoc = a.config().SubtractSocial
+	a.dbMu.Unlock()
+	a.dbMu.RLock()
+	defer a.dbMu.RUnlock()
+	err := a.Parser.WriteFiles(dir)
+	if err != nil {
+		return fmt.Errorf("WriteFiles: %w", err)
+	}
+	return nil
+}
 
-				}
-			}
-			a.Parser.ParseSoc(item)
+// writeDumpChanges write entries added and removed by dump to dir, file
+// of earlier run is removed when there is no previous dump to compare
+func (a *App) writeDumpChanges(dir string) error {
+	fn := filepath.Join(dir, parser.DumpChangesFile)
+	a.mu.RLock()
+	changes, diff := a.dumpChanges, a.dumpDiff
+	a.mu.RUnlock()
+	if !diff {
+		err := os.Remove(fn)
+		if err != nil && !os.IsNotExist(err) {
+			return err
 		}
+		return nil
 	}
-	err = a.Parser.WriteSocialFiles("output")
+	err := changes.WriteFile(fn)
 	if err != nil {
-		return err
+		return fmt.Errorf("write dump changes: %w", err)
 	}
-	log.Println("end read social file")
 	return nil
 }
 
 // DumpDownloader download dump
-func (a *App) DumpDownloader(i time.Duration) {
-	dd, _ := downloader.LoadDumpDate()
+func (a *App) DumpDownloader(ctx context.Context) {
+	defer a.waitGroup.Done()
+	defer a.running("dump_downloader")()
+	dd, _ := a.State.LoadDumpDate()
 	log.Println("loaded dumpdate", dd, time.Unix(int64(dd/1000), 0))
+	if dd != 0 && !a.restoreDump(ctx, dd) {
+		// lists are empty until dump is applied again
+		dd = 0
+	}
+	interval := func(c Config) time.Duration {
+		return time.Duration(c.DumpInterval) * time.Minute
+	}
+	var last time.Time
+	if dd != 0 {
+		last = time.Now()
+	}
 	for {
-		if dd != 0 && !a.Config.Cron {
-			time.Sleep(i)
-		}
-		res, err := a.Downloader.SOAP.Call("getLastDumpDate", nil)
-		if err != nil {
-			log.Printf("Call getLastDumpDate: %s", err)
-			time.Sleep(30 * time.Second)
-			continue
-		}
-		var rd downloader.GetdateRes
-		err = res.Unmarshal(&rd)
-		if err != nil {
-			log.Println("unmarshal", err)
-			time.Sleep(30 * time.Second)
-			continue
+		if !a.config().Cron && !a.waitNext(ctx, last, interval, a.dumpNow) {
+			log.Println("dump downloader stopped")
+			return
 		}
-		log.Println("got dump date", rd.Date, time.Unix(int64(dd/1000), 0))
-		if rd.Date == dd && !a.Config.Cron {
-			continue
+		if !a.allowTry(ctx, "dump", a.dumpRetry, a.dumpNow) {
+			log.Println("dump downloader stopped")
+			return
 		}
-		dd = rd.Date
-		err = downloader.SaveDumpDate(dd)
+		last = time.Now()
+		nd, err := a.updateDump(ctx, dd)
 		if err != nil {
-			log.Println("can't save dumpdate", err)
-			time.Sleep(30 * time.Second)
-			continue
-		}
-		res, err = a.Downloader.SOAP.Call("getResult", gosoap.Params{})
-		if err != nil {
-			log.Printf("Call getResult: %s", err)
-			time.Sleep(30 * time.Second)
-			continue
-		}
-		var r downloader.Resp
-		err = res.Unmarshal(&r)
-		if err != nil {
-			log.Println("unmarshal", err)
-			time.Sleep(30 * time.Second)
-			continue
-		}
-		b, err := base64.StdEncoding.DecodeString(string(r.Zip))
-		if err != nil {
-			log.Println("can't unmarshal", err)
-			time.Sleep(30 * time.Second)
-			continue
-		}
-		fn, err := downloader.FindXMLInZipAndSave(b)
-		if err != nil {
-			log.Println("FindXMLInZipAndSave", err)
-			time.Sleep(30 * time.Second)
+			if ctx.Err() != nil {
+				log.Println("dump cycle aborted", err)
+				return
+			}
+			if !a.retryFailed(ctx, "dump", a.dumpRetry, err, a.dumpNow) {
+				return
+			}
+			last = time.Time{}
 			continue
 		}
-		err = a.ReadDumpFile(fn)
-		if err != nil {
-			log.Println("ReadDumpFile", err)
-			time.Sleep(30 * time.Second)
-			continue
+		dd = nd
+		a.dumpRetry.Success()
+		metrics.Set("rkndaemon_download_circuit_state", 0, "source", "dump")
+		if a.config().Cron {
+			fmt.Println("cron detected exit")
+			return
 		}
-		err = a.Parser.WriteFiles("output")
+	}
+}
+
+// updateDump run one dump cycle, returns new dump date
+func (a *App) updateDump(ctx context.Context, dd int) (int, error) {
+	cfg := a.config()
+	dwn := a.downloader()
+	res, err := dwn.Call(ctx, "getLastDumpDate", nil)
+	if err != nil {
+		return dd, fmt.Errorf("call getLastDumpDate: %w", err)
+	}
+	var rd downloader.GetdateRes
+	err = res.Unmarshal(&rd)
+	if err != nil {
+		return dd, fmt.Errorf("unmarshal: %w", downloader.ParseError(err))
+	}
+	log.Println("got dump date", rd.Date, time.Unix(int64(rd.Date/1000), 0))
+	a.watchChecked(rd.Date)
+	if rd.Date == dd && !cfg.Cron {
+		return dd, nil
+	}
+	zfn := a.State.ZipFile("dump")
+	_, size, err := dwn.DownloadZip(ctx, "getResult", zfn)
+	if err != nil {
+		return dd, fmt.Errorf("getResult: %w", err)
+	}
+	log.Println("dump downloaded", downloader.ByteCountIEC(size))
+	if ar := a.archive(); ar != nil {
+		afn, err := ar.SaveFile("dump", time.Unix(int64(rd.Date/1000), 0), zfn)
 		if err != nil {
-			log.Println("WriteFiles", err)
-			time.Sleep(30 * time.Second)
-			continue
+			log.Println("can't archive dump", err)
+		} else {
+			log.Println("dump archived", afn)
 		}
+	}
+	xr, fn, err := a.openXML(zfn, cfg.SaveXML)
+	if err != nil {
+		return dd, fmt.Errorf("open xml: %w", downloader.ParseError(err))
+	}
+	err = a.ProcessDump(ctx, xr, cfg.OutputDir)
+	xr.Close()
+	if err != nil {
+		return dd, err
+	}
+	// saved only after outputs are written, so an aborted cycle is repeated
+	err = a.State.SaveDumpDate(rd.Date)
+	if err != nil {
+		log.Println("can't save dumpdate", err)
+	}
+	a.watchApplied(rd.Date)
 
-		if a.Config.UseResolver {
-			a.Resolve()
-		}
-		if a.Config.PostScript != "" &&
-			!strings.ContainsAny(a.Config.PostScript, "|;`*?") {
-			cmd := exec.Command(path.Clean(a.Config.PostScript)) // nolint
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				log.Println("PostScript", err)
-			}
-			log.Println("PostScript", string(out))
-		}
-		if a.Config.Cron {
-			fmt.Println("cron detected exit")
-			break
-		}
+	if cfg.UseResolver {
+		a.Resolve(ctx)
 	}
-	a.waitGroup.Done()
+	a.fire(ctx, a.dumpEvent(rd.Date, fn))
+	return rd.Date, nil
 }
 
 // SocialDownloader download social resources
-func (a *App) SocialDownloader(i time.Duration) {
+func (a *App) SocialDownloader(ctx context.Context) {
+	defer a.waitGroup.Done()
+	defer a.running("social_downloader")()
+	interval := func(c Config) time.Duration {
+		return time.Duration(c.SocialInterval) * time.Minute
+	}
 	for {
-		res, err := a.Downloader.SOAP.Call("getResultSocResources", gosoap.Params{})
+		if !a.allowTry(ctx, "social", a.socRetry, a.socNow) {
+			log.Println("social downloader stopped")
+			return
+		}
+		last := time.Now()
+		err := a.updateSocial(ctx)
 		if err != nil {
-			if strings.HasPrefix(err.Error(), "XML syntax error") {
-				log.Println("are u add server IP to https://service.rkn.gov.ru/monitoring/vigruzka")
+			if ctx.Err() != nil {
+				log.Println("social cycle aborted", err)
+				return
+			}
+			if !a.retryFailed(ctx, "social", a.socRetry, err, a.socNow) {
+				return
 			}
-			log.Printf("social download error: %s", err)
-			time.Sleep(30 * time.Second)
 			continue
 		}
-		var r downloader.Resp
-		err = res.Unmarshal(&r)
-		if err != nil {
-			log.Printf("socialUnmarshal: %s", err)
-		}
-		b, err := base64.StdEncoding.DecodeString(string(r.Zip))
-		if err != nil {
-			log.Printf("socialDecodeString: %s", err)
-		}
-		fn, err := downloader.FindXMLInZipAndSave(b)
-		if err != nil {
-			log.Printf("socialFindXMLInZipAndSave: %s", err)
+		a.socRetry.Success()
+		metrics.Set("rkndaemon_download_circuit_state", 0, "source", "social")
+		if a.config().Cron {
+			fmt.Println("social cron detected exit")
+			return
 		}
-		err = a.ReadSocialFile(fn)
-		if err != nil {
-			log.Printf("socialReadSocialFilee: %s", err)
+		if !a.waitNext(ctx, last, interval, a.socNow) {
+			log.Println("social downloader stopped")
+			return
 		}
+	}
+}
 
-		if a.Config.SocialScript != "" &&
-			!strings.ContainsAny(a.Config.SocialScript, "|;`*?") {
-			cmd := exec.Command(path.Clean(a.Config.SocialScript)) // nolint
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				log.Println("SocialScript", err)
-			}
-			log.Println("SocialScript", string(out))
-		}
-		if !a.Config.Cron {
-			time.Sleep(i)
-		} else {
-			fmt.Println("social cron detected exit")
-			break
-		}
+// dumpEvent dump-updated event with list sizes, added, removed and
+// changes_file are set only when previous dump was read in this process
+func (a *App) dumpEvent(date int, fn string) Event {
+	a.mu.RLock()
+	changes, diff := a.dumpChanges, a.dumpDiff
+	a.mu.RUnlock()
+	a.dbMu.RLock()
+	defer a.dbMu.RUnlock()
+	e := NewEvent(EventDumpUpdated,
+		"dump_date", time.Unix(int64(date/1000), 0),
+		"dump_date_ms", date,
+		"xml_file", fn,
+		"urls", len(a.Parser.URLs),
+		"domains", len(a.Parser.Domains),
+		"masks", len(a.Parser.DomainMasks),
+		"ips", len(a.Parser.BlockedIPs),
+		"subnets", len(a.Parser.Subnets),
+	)
+	// without previous dump every entry would look added
+	if diff {
+		e.Data["added"] = changes.Added.Len()
+		e.Data["removed"] = changes.Removed.Len()
+		e.Data["changes_file"] = filepath.Join(a.config().OutputDir, parser.DumpChangesFile)
+	}
+	return e
+}
+
+// socialState returns state of last social register
+func (a *App) socialState() downloader.SocialState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.social
+}
 
+// setSocialState remember and save social register state
+func (a *App) setSocialState(ss downloader.SocialState) {
+	a.mu.Lock()
+	a.social = ss
+	a.mu.Unlock()
+	metrics.Set("rkndaemon_social_last_checked_timestamp_seconds", float64(ss.Checked.Unix()))
+	metrics.Set("rkndaemon_social_last_changed_timestamp_seconds", float64(ss.Changed.Unix()))
+	err := a.State.SaveSocial(ss)
+	if err != nil {
+		log.Println("can't save social state", err)
 	}
-	a.waitGroup.Done()
 }
 
-// Resolve all domains from parser
-func (a *App) Resolve() {
-	log.Printf("start resolving on %d workers", a.Config.WorkerCount)
-	t := time.Now()
-	cnt := 0
-	pps := 0
-	all := len(a.Parser.URLs)
-	skip := 0
-	resolved := make(map[string]bool)
-	for k := range a.Parser.URLs {
-		u, err := url.Parse(k)
-		if err != nil {
-			continue
+// allowTry wait until circuit of source allows a try, open circuit
+// rejects tries, also forced ones, until its pause ends and then lets one
+// probe through. Returns false if ctx canceled
+func (a *App) allowTry(ctx context.Context, source string, r *downloader.Retry, now <-chan struct{}) bool {
+	for {
+		ok, wait := r.Allow()
+		if ok {
+			break
 		}
-		if !resolved[u.Hostname()] {
-			a.Resolver.AddToQueue(u)
-			skip++
+		log.Println(source, "circuit open, next probe in", wait.Truncate(time.Second))
+		if !sleep(ctx, wait, now) {
+			return false
 		}
-		resolved[u.Hostname()] = true
-		cnt++
-		pps++
-		if time.Since(t) > time.Second*10 {
-			log.Printf("resolve speed %d per second, %3.2f%% done, skip %d", pps/10, float64(cnt)/float64(all)*100, skip)
-			t = time.Now()
-			pps = 0
-			skip = 0
+	}
+	if r.State().Circuit == downloader.CircuitHalfOpen {
+		log.Println(source, "circuit half-open, probe")
+		metrics.Set("rkndaemon_download_circuit_state", 1, "source", source)
+	}
+	return true
+}
+
+// restoreDump read lists of last applied dump with date dd from archive
+// or state zip, so after restart api shows registry and next dump gets
+// right diff, outputs are already written and are not touched. Returns
+// false when dump can't be read
+func (a *App) restoreDump(ctx context.Context, dd int) bool {
+	zfn := a.State.ZipFile("dump")
+	t := time.Unix(int64(dd/1000), 0)
+	if ar := a.archive(); ar != nil {
+		e, err := ar.Find("dump", t)
+		if err == nil && e.Time.Equal(t) {
+			zfn = e.Path
 		}
+	}
+	rc, _, err := downloader.OpenXMLInZip(zfn)
+	if err != nil {
+		log.Println("can't restore last dump", err)
+		return false
+	}
+	defer rc.Close()
+	err = a.ReadDump(ctx, rc)
+	if err != nil {
+		log.Println("can't restore last dump", err)
+		return false
+	}
+	a.mu.Lock()
+	a.dumpReady = true
+	a.mu.Unlock()
+	log.Println("last dump restored from", zfn)
+	return true
+}
+
+// openXML returns reader of xml in downloaded zip, with savexml it is
+// extracted to state xml dir first and its path is returned
+func (a *App) openXML(zfn string, save bool) (io.ReadCloser, string, error) {
+	if !save {
+		rc, _, err := downloader.OpenXMLInZip(zfn)
+		return rc, "", err
+	}
+	fn, err := downloader.FindXMLInZipFile(zfn, a.State.XMLDir())
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := os.Open(fn)
+	return f, fn, err
+}
+
+// retryFailed report failed download cycle and wait before retry, wait
+// is interrupted by refresh, returns false if ctx canceled
+func (a *App) retryFailed(ctx context.Context, source string, r *downloader.Retry, err error, now <-chan struct{}) bool {
+	kind, wait := r.Failure(err)
+	st := r.State()
+	log.Printf("%s %s error (%d in a row, circuit %s), retry in %s: %v", source, kind, st.Failures, st.Circuit, wait.Truncate(time.Second), err)
+	switch kind {
+	case downloader.KindAuth:
+		log.Println("check rknuser and rknpass")
+	case downloader.KindNotWhitelisted:
+		log.Println("are u add server IP to https://service.rkn.gov.ru/monitoring/vigruzka")
+	}
+	metrics.Add("rkndaemon_download_errors_total", 1, "source", source, "kind", kind)
+	metrics.Set("rkndaemon_download_circuit_state", 2, "source", source)
+	if st.Circuit == downloader.CircuitClosed {
+		metrics.Set("rkndaemon_download_circuit_state", 0, "source", source)
+	}
+	a.fire(ctx, NewEvent(EventDownloadFailed,
+		"source", source,
+		"error", err.Error(),
+		"kind", kind,
+		"failures", st.Failures,
+		"circuit", st.Circuit,
+		"retry_at", st.RetryAt,
+	))
+	return sleep(ctx, wait, now)
+}
+
+// sleep wait for d, returns early when something sent to now,
+// returns false if ctx canceled
+func sleep(ctx context.Context, d time.Duration, now <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-now:
+		return true
+	case <-t.C:
+		return true
+	}
+}
 
+// waitNext wait until interval after last passed, interval is recalculated
+// on config reload, returns false if ctx canceled
+func (a *App) waitNext(ctx context.Context, last time.Time, interval func(Config) time.Duration, now <-chan struct{}) bool {
+	for {
+		a.mu.RLock()
+		reloaded := a.reloaded
+		d := time.Until(last.Add(interval(a.Config)))
+		a.mu.RUnlock()
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return false
+		case <-now:
+			t.Stop()
+			return true
+		case <-t.C:
+			return true
+		case <-reloaded:
+			t.Stop()
+		}
 	}
-	a.Resolver.Close()
-	log.Println("end resolving")
-	a.Resolver = resolver.New(a.Config.DNSServers)
-	a.Resolver.Run(a.Config.WorkerCount, a.Config.ResolverFile)
 }
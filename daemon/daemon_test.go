@@ -0,0 +1,39 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prgra/rkndaemon/downloader"
+)
+
+func TestAllowTryOpenCircuit(t *testing.T) {
+	a, err := NewOffline(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := downloader.NewRetry(100*time.Millisecond, time.Second, 1)
+	r.Jitter = 0
+	r.Failure(errors.New("connection refused"))
+	start := time.Now()
+	now := make(chan struct{}, 1)
+	// forced try does not bypass open circuit
+	now <- struct{}{}
+	if !a.allowTry(context.Background(), "dump", r, now) {
+		t.Fatal("allowTry canceled")
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("try allowed after %s while circuit is open", d)
+	}
+	if st := r.State(); st.Circuit != downloader.CircuitHalfOpen {
+		t.Errorf("circuit %s, want half-open probe", st.Circuit)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if a.allowTry(ctx, "dump", r, nil) {
+		t.Error("second try allowed while probe is running")
+	}
+}
@@ -0,0 +1,180 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prgra/rkndaemon/downloader"
+	"github.com/prgra/rkndaemon/parser"
+)
+
+// dumpEntries count of entries compared between dumps
+func dumpEntries(a *App) int {
+	p := a.Parser
+	return len(p.URLs) + len(p.Domains) + len(p.DomainMasks) + len(p.BlockedIPs) + len(p.Subnets)
+}
+
+func copyZip(t *testing.T, src string, dst string) {
+	t.Helper()
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(dst, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreDump(t *testing.T) {
+	const dd = 1700000000000
+	st, err := downloader.NewState(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewOffline(Config{StateDir: st.Dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyZip(t, writeZip(t, dumpXML(100), zipOK), st.ZipFile("dump"))
+	a.restoreDump(context.Background(), dd)
+	if len(a.Parser.Domains) != 100 {
+		t.Fatalf("restored %d domains, want 100", len(a.Parser.Domains))
+	}
+	e := a.dumpEvent(dd, "")
+	if _, ok := e.Data["added"]; ok {
+		t.Errorf("restored dump reports added %v", e.Data["added"])
+	}
+
+	restored := dumpEntries(a)
+	xr, _, err := downloader.OpenXMLInZip(writeZip(t, dumpXML(150), zipOK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.ReadDump(context.Background(), xr)
+	xr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = a.dumpEvent(dd+1000, "")
+	if e.Data["added"] != dumpEntries(a)-restored || e.Data["removed"] != 0 {
+		t.Errorf("diff after restore added %v removed %v, want %d and 0", e.Data["added"], e.Data["removed"], dumpEntries(a)-restored)
+	}
+}
+
+func TestRestoreDumpArchive(t *testing.T) {
+	const dd = 1700000000000
+	st, err := downloader.NewState(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewOffline(Config{StateDir: st.Dir, UseArchive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// state zip holds newer dump which was downloaded but not applied
+	copyZip(t, writeZip(t, dumpXML(50), zipOK), st.ZipFile("dump"))
+	_, err = a.Archive.SaveFile("dump", time.Unix(dd/1000, 0), writeZip(t, dumpXML(100), zipOK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.restoreDump(context.Background(), dd)
+	if len(a.Parser.Domains) != 100 {
+		t.Fatalf("restored %d domains, want 100 from archive", len(a.Parser.Domains))
+	}
+
+	// without dump readable lists stay empty
+	b, err := NewOffline(Config{StateDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.restoreDump(context.Background(), dd) || len(b.Parser.Domains) != 0 || b.dumpLoaded {
+		t.Errorf("missing dump restored %d domains", len(b.Parser.Domains))
+	}
+}
+
+func TestReadinessNeedsDumpInProcess(t *testing.T) {
+	const dd = 1700000000000
+	st, err := downloader.NewState(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewOffline(Config{StateDir: st.Dir, UseDump: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// saved dump date alone does not make lists readable
+	a.initWatch(dd)
+	if h := a.Readiness(time.Now()); h.OK {
+		t.Fatalf("ready with saved dump date only: %+v", h)
+	}
+	copyZip(t, writeZip(t, dumpXML(10), zipOK), st.ZipFile("dump"))
+	if !a.restoreDump(context.Background(), dd) {
+		t.Fatal("dump not restored")
+	}
+	if h := a.Readiness(time.Now()); !h.OK {
+		t.Errorf("not ready after restore: %s", h.Problems())
+	}
+}
+
+func TestDumpChangesFile(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewOffline(Config{OutputDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfn := filepath.Join(dir, parser.DumpChangesFile)
+	os.WriteFile(cfn, []byte("{}"), 0644) // nolint
+	err = a.ProcessDump(context.Background(), bytes.NewReader(dumpXML(3)), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(cfn); !os.IsNotExist(err) {
+		t.Errorf("changes file of earlier run kept without previous dump: %v", err)
+	}
+	if _, ok := a.dumpEvent(1, "").Data["changes_file"]; ok {
+		t.Error("changes_file passed without previous dump")
+	}
+
+	err = a.ProcessDump(context.Background(), bytes.NewReader(dumpXML(4)), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := a.dumpEvent(2, "")
+	if e.Data["changes_file"] != cfn {
+		t.Fatalf("changes_file %v, want %s", e.Data["changes_file"], cfn)
+	}
+	b, err := os.ReadFile(cfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d parser.DumpDiff
+	err = json.Unmarshal(b, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Added.Len() == 0 || d.Added.Len() != e.Data["added"] || d.Removed.Len() != 0 {
+		t.Errorf("changes %+v, event added %v", d, e.Data["added"])
+	}
+	for _, dom := range d.Added.Domains {
+		if !a.Parser.Domains[dom] {
+			t.Errorf("added domain %s is not in lists", dom)
+		}
+	}
+
+	err = a.ProcessDump(context.Background(), bytes.NewReader(dumpXML(2)), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(cfn)
+	d = parser.DumpDiff{}
+	json.Unmarshal(b, &d) // nolint
+	if d.Added.Len() != 0 || len(d.Removed.Domains) == 0 {
+		t.Errorf("changes after shrink %+v", d)
+	}
+}
@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prgra/rkndaemon/downloader"
+	"github.com/prgra/rkndaemon/sdnotify"
+)
+
+// Health result of liveness or readiness check, Checks has "ok" or
+// problem for every checked part
+type Health struct {
+	OK     bool              `json:"ok"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (h *Health) check(name string, problem string) {
+	if h.Checks == nil {
+		h.Checks = make(map[string]string)
+	}
+	if problem == "" {
+		h.Checks[name] = "ok"
+		return
+	}
+	h.Checks[name] = problem
+	h.OK = false
+}
+
+// Problems returns failed checks as text
+func (h Health) Problems() string {
+	var l []string
+	for k, v := range h.Checks {
+		if v != "ok" {
+			l = append(l, k+": "+v)
+		}
+	}
+	sort.Strings(l)
+	return strings.Join(l, "; ")
+}
+
+// running mark background worker as running, returned func marks it
+// stopped, use as defer a.running("name")()
+func (a *App) running(name string) func() {
+	a.mu.Lock()
+	if a.workers == nil {
+		a.workers = make(map[string]bool)
+	}
+	a.workers[name] = true
+	a.mu.Unlock()
+	return func() {
+		a.mu.Lock()
+		a.workers[name] = false
+		a.mu.Unlock()
+	}
+}
+
+// Liveness check that background workers are running
+func (a *App) Liveness() Health {
+	h := Health{OK: true}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.stopping {
+		h.check("daemon", "stopping")
+		return h
+	}
+	for name, ok := range a.workers {
+		if ok {
+			h.check(name, "")
+		} else {
+			h.check(name, "stopped")
+		}
+	}
+	return h
+}
+
+// Readiness check that workers are running, dump is applied or restored
+// in this process and dump is not critically stale
+func (a *App) Readiness(now time.Time) Health {
+	h := a.Liveness()
+	cfg := a.config()
+	if cfg.UseDump {
+		w := a.DumpWatch(now)
+		a.mu.RLock()
+		ready := a.dumpReady
+		a.mu.RUnlock()
+		switch {
+		case !ready:
+			h.check("dump", "no dump applied yet")
+		case w.Alert == StaleCritical:
+			h.check("dump", fmt.Sprintf("stale for %s", time.Duration(w.Age)*time.Second))
+		default:
+			h.check("dump", "")
+		}
+	}
+	if cfg.UseSoc {
+		if a.socialState().Checked.IsZero() {
+			h.check("social", "social register not downloaded yet")
+		} else {
+			h.check("social", "")
+		}
+	}
+	return h
+}
+
+// HealthzHandler liveness, /healthz
+func (a *App) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, a.Liveness())
+}
+
+// ReadyzHandler readiness, /readyz
+func (a *App) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, a.Readiness(time.Now()))
+}
+
+func writeHealth(w http.ResponseWriter, h Health) {
+	w.Header().Set("Content-Type", "application/json")
+	if !h.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(h)
+	if err != nil {
+		log.Println("writeHealth", err)
+	}
+}
+
+// supervise report state to systemd: READY after start, STATUS and
+// WATCHDOG pings while workers are alive, STOPPING on shutdown
+func (a *App) supervise(ctx context.Context) {
+	notify := func(state string) {
+		_, err := sdnotify.Notify(state)
+		if err != nil {
+			log.Println("sd_notify", err)
+		}
+	}
+	notify(sdnotify.Ready)
+	tick := time.Minute
+	wd := sdnotify.WatchdogInterval()
+	if wd > 0 && wd/2 < tick {
+		tick = wd / 2
+	}
+	status := ""
+	for {
+		if l := a.Liveness(); l.OK && wd > 0 {
+			notify(sdnotify.Watchdog)
+		}
+		s := "ready"
+		if r := a.Readiness(time.Now()); !r.OK {
+			s = "not ready: " + r.Problems()
+		}
+		if s != status {
+			notify(sdnotify.Status(s))
+			status = s
+		}
+		if !sleep(ctx, tick, nil) {
+			break
+		}
+	}
+	a.mu.Lock()
+	a.stopping = true
+	a.mu.Unlock()
+	notify(sdnotify.Stopping)
+}
+
+// HealthURL returns url of health endpoint of daemon listening on addr
+func HealthURL(addr string, live bool) (string, error) {
+	if addr == "" {
+		return "", fmt.Errorf("http listener is not configured")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	p := "/readyz"
+	if live {
+		p = "/healthz"
+	}
+	return "http://" + net.JoinHostPort(host, port) + p, nil
+}
+
+// CheckLocal check state without running daemon: dump date is saved
+// and applied dump was confirmed as latest not earlier than stalecritical
+func (c *Config) CheckLocal(now time.Time) error {
+	if !c.UseDump {
+		return nil
+	}
+	st := downloader.State{Dir: c.StateDir}
+	synced := st.DumpSynced()
+	if synced.IsZero() {
+		return fmt.Errorf("no dump applied yet")
+	}
+	age := now.Sub(synced)
+	if c.StaleCritical > 0 && age >= time.Duration(c.StaleCritical)*time.Minute {
+		return fmt.Errorf("dump confirmed as latest %s ago", age.Truncate(time.Second))
+	}
+	return nil
+}
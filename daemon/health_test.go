@@ -0,0 +1,60 @@
+package daemon
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prgra/rkndaemon/downloader"
+)
+
+func TestCheckLocal(t *testing.T) {
+	now := time.Now()
+	st, err := downloader.NewState(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{StateDir: st.Dir, UseDump: true, StaleCritical: 60}
+	if cfg.CheckLocal(now) == nil {
+		t.Fatal("ready without applied dump")
+	}
+	err = st.SaveDumpDate(1700000000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dump applied long ago and registry had no newer one since
+	applied := now.Add(-3 * time.Hour)
+	err = os.Chtimes(st.DumpDateFile(), applied, applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CheckLocal(now) == nil {
+		t.Fatal("ready with stale dump")
+	}
+	a, err := NewOffline(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.initWatch(1700000000000)
+	a.watchChecked(1700000000000)
+	err = cfg.CheckLocal(now.Add(time.Minute))
+	if err != nil {
+		t.Errorf("confirmed dump is not ready: %v", err)
+	}
+	if w := a.DumpWatch(now); !w.Synced.After(applied) {
+		t.Errorf("synced %s, want after confirmation", w.Synced)
+	}
+
+	// next start counts age from confirmation, not from applying
+	b, err := NewOffline(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.initWatch(1700000000000)
+	if w := b.DumpWatch(now.Add(time.Minute)); w.Alert != StaleOK {
+		t.Errorf("alert %s after restart, age %v", w.Alert, w.Age)
+	}
+	if cfg.CheckLocal(now.Add(2*time.Hour)) == nil {
+		t.Error("ready when confirmation is stale")
+	}
+}
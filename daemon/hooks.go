@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prgra/rkndaemon/metrics"
+)
+
+// hook events
+const (
+	EventDumpUpdated    = "dump-updated"
+	EventSocialUpdated  = "social-updated"
+	EventResolveDone    = "resolve-finished"
+	EventDownloadFailed = "download-failed"
+	EventDumpStale      = "dump-stale"
+)
+
+var events = []string{EventDumpUpdated, EventSocialUpdated, EventResolveDone, EventDownloadFailed, EventDumpStale}
+
+// defaultHookTimeout used when hook has no timeout
+const defaultHookTimeout = 5 * time.Minute
+
+func init() {
+	metrics.Describe("rkndaemon_hook_runs_total", "counter", "hook runs by event, hook and result")
+	metrics.Describe("rkndaemon_hook_last_exit_code", "gauge", "exit code of last hook run, -1 if not started or killed")
+	metrics.Describe("rkndaemon_hook_duration_seconds", "gauge", "duration of last hook run")
+}
+
+// Hook command run on event, toml:
+//
+//	[[hooks]]
+//	event = "dump-updated"
+//	command = "/usr/local/bin/reload-ipset"
+//	args = ["${RKN_OUTPUT_DIR}/bloked_ips.txt"]
+//	env = ["IPSET=rkn"]
+//	timeout = 60 # seconds
+//	retries = 2
+//	delay = 10 # seconds between retries
+type Hook struct {
+	Event   string
+	Command string
+	Args    []string
+	Env     []string
+	Timeout int64
+	Retries int64
+	Delay   int64
+}
+
+// Event data passed to hooks as RKN_<KEY> environment variables
+type Event struct {
+	Name string
+	Time time.Time
+	Data map[string]interface{}
+}
+
+// NewEvent create event with data from key value pairs
+func NewEvent(name string, kv ...interface{}) Event {
+	e := Event{Name: name, Time: time.Now(), Data: make(map[string]interface{})}
+	for i := 0; i+1 < len(kv); i += 2 {
+		e.Data[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	return e
+}
+
+// Env returns event as environment variables
+func (e Event) Env() []string {
+	env := []string{
+		"RKN_EVENT=" + e.Name,
+		"RKN_TIME=" + e.Time.Format(time.RFC3339),
+	}
+	for k, v := range e.Data {
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339)
+		}
+		env = append(env, fmt.Sprintf("RKN_%s=%v", strings.ToUpper(k), v))
+	}
+	sort.Strings(env[2:])
+	return env
+}
+
+// validateEvent check hook event is known
+func (h Hook) validateEvent() error {
+	for _, e := range events {
+		if h.Event == e {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event %q, need one of %s", h.Event, strings.Join(events, ", "))
+}
+
+// validate check hook event and options, missing command is only logged
+func (h Hook) validate() error {
+	err := h.validateEvent()
+	if err != nil {
+		return err
+	}
+	if h.Command == "" {
+		return fmt.Errorf("hook for %s has no command", h.Event)
+	}
+	if h.Timeout < 0 || h.Retries < 0 || h.Delay < 0 {
+		return fmt.Errorf("hook %s: timeout, retries and delay can't be negative", h.Command)
+	}
+	// command can be installed after start, runs fail until then
+	_, err = exec.LookPath(h.Command)
+	if err != nil {
+		log.Printf("warning: hook %s for %s: %v", h.Command, h.Event, err)
+	}
+	return nil
+}
+
+// hooks returns configured hooks with legacy postscript and socialscript
+func (c *Config) hooks() []Hook {
+	hooks := c.Hooks
+	if c.PostScript != "" {
+		hooks = append(hooks, Hook{Event: EventDumpUpdated, Command: c.PostScript})
+	}
+	if c.SocialScript != "" {
+		hooks = append(hooks, Hook{Event: EventSocialUpdated, Command: c.SocialScript})
+	}
+	return hooks
+}
+
+// fire send event to webhooks and run hooks of event one by one
+func (a *App) fire(ctx context.Context, e Event) {
+	cfg := a.config()
+	e.Data["output_dir"] = cfg.OutputDir
+	a.sendWebhooks(ctx, cfg.Webhooks, e)
+	for _, h := range cfg.hooks() {
+		if h.Event == e.Name {
+			a.runHook(ctx, h, e)
+		}
+	}
+}
+
+// runHook run hook with retries, output is logged
+func (a *App) runHook(ctx context.Context, h Hook, e Event) {
+	evenv := e.Env()
+	env := append(os.Environ(), evenv...)
+	env = append(env, h.Env...)
+	// only event variables are expanded in args, the rest is kept
+	// for shell scripts
+	lookup := func(k string) string {
+		for _, kv := range evenv {
+			if strings.HasPrefix(kv, k+"=") {
+				return strings.TrimPrefix(kv, k+"=")
+			}
+		}
+		return "${" + k + "}"
+	}
+	args := make([]string, len(h.Args))
+	for i := range h.Args {
+		args[i] = os.Expand(h.Args[i], lookup)
+	}
+	timeout := time.Duration(h.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	name := filepath.Base(h.Command)
+	for try := int64(0); try <= h.Retries; try++ {
+		if try > 0 && !sleep(ctx, time.Duration(h.Delay)*time.Second, nil) {
+			return
+		}
+		hctx, cancel := context.WithTimeout(ctx, timeout)
+		cmd := exec.CommandContext(hctx, h.Command, args...) // nolint
+		cmd.Env = env
+		start := time.Now()
+		out, err := cmd.CombinedOutput()
+		cancel()
+		code := -1
+		if cmd.ProcessState != nil {
+			code = cmd.ProcessState.ExitCode()
+		}
+		result := "ok"
+		var ee *exec.ExitError
+		switch {
+		case err == nil:
+		case hctx.Err() == context.DeadlineExceeded:
+			result = "timeout"
+		case errors.As(err, &ee):
+			result = "fail"
+		default:
+			result = "error"
+		}
+		metrics.Add("rkndaemon_hook_runs_total", 1, "event", e.Name, "hook", name, "result", result)
+		metrics.Set("rkndaemon_hook_last_exit_code", float64(code), "event", e.Name, "hook", name)
+		metrics.Set("rkndaemon_hook_duration_seconds", time.Since(start).Seconds(), "event", e.Name, "hook", name)
+		if len(out) > 0 {
+			log.Printf("hook %s %s: %s", e.Name, name, out)
+		}
+		if err == nil {
+			return
+		}
+		log.Printf("hook %s %s %s (try %d of %d): %v", e.Name, name, result, try+1, h.Retries+1, err)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
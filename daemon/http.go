@@ -1,15 +1,30 @@
 package daemon
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prgra/rkndaemon/parser"
+	"github.com/prgra/rkndaemon/resolver"
+	"golang.org/x/net/idna"
 )
 
 // AuthMiddleware is a middleware to check if the request has a valid token
 func (a *App) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		stoken := r.Header.Get("X-Auth-Token")
-		if stoken == "" || stoken != a.Config.HTTPToken {
+		if stoken == "" {
+			// prometheus can send only Authorization header
+			stoken = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if stoken == "" || stoken != a.config().HTTPToken {
 			w.WriteHeader(http.StatusOK)
 			_, err := w.Write([]byte(""))
 			if err != nil {
@@ -21,3 +36,91 @@ func (a *App) AuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// LookupResult answer of lookup api
+type LookupResult struct {
+	Query    string              `json:"query"`
+	Lists    []string            `json:"lists"`
+	Subnets  []string            `json:"subnets,omitempty"`
+	Resolved []resolver.MapEntry `json:"resolved,omitempty"`
+	Reason   []string            `json:"reason"`
+}
+
+// LookupHandler explain why ip or domain is blocked,
+// /api/lookup?ip=1.2.3.4 or /api/lookup?domain=example.com
+func (a *App) LookupHandler(w http.ResponseWriter, r *http.Request) {
+	var res LookupResult
+	cfg := a.config()
+	grace := time.Duration(cfg.ResolverGrace) * time.Hour
+	if q := r.URL.Query().Get("ip"); q != "" {
+		ip := net.ParseIP(q)
+		if ip == nil {
+			http.Error(w, "bad ip", http.StatusBadRequest)
+			return
+		}
+		res.Query = ip.String()
+		a.dbMu.RLock()
+		for _, l := range []struct {
+			name string
+			list parser.List
+		}{
+			{"bloked_ips.txt", a.Parser.BlockedIPs},
+			{"allips.txt", a.Parser.AllIPs},
+			{"https_ips.txt", a.Parser.HTTPSIPs},
+		} {
+			if l.list[res.Query] {
+				res.Lists = append(res.Lists, l.name)
+				res.Reason = append(res.Reason, fmt.Sprintf("ip listed in registry (%s)", l.name))
+			}
+		}
+		for k := range a.Parser.Subnets {
+			_, n, err := net.ParseCIDR(k)
+			if err == nil && n.Contains(ip) {
+				res.Subnets = append(res.Subnets, k)
+				res.Reason = append(res.Reason, fmt.Sprintf("ip in blocked subnet %s", k))
+			}
+		}
+		a.dbMu.RUnlock()
+		if a.ResolverCache != nil {
+			res.Resolved = a.ResolverCache.Lookup(res.Query, time.Now(), grace)
+			for _, e := range res.Resolved {
+				res.Reason = append(res.Reason, fmt.Sprintf("ip blocked because it resolves from %s (%s)", e.Domain, e.Server))
+			}
+			if len(res.Resolved) > 0 {
+				res.Lists = append(res.Lists, filepath.Base(cfg.resolverFile()))
+			}
+		}
+	} else if q := r.URL.Query().Get("domain"); q != "" {
+		d, err := idna.ToASCII(strings.ToLower(strings.TrimSuffix(q, ".")))
+		if err != nil {
+			http.Error(w, "bad domain", http.StatusBadRequest)
+			return
+		}
+		res.Query = d
+		a.dbMu.RLock()
+		if a.Parser.Domains[d] {
+			res.Lists = append(res.Lists, "domains.txt")
+			res.Reason = append(res.Reason, "domain listed in registry")
+		}
+		for m := range a.Parser.DomainMasks {
+			base := strings.TrimPrefix(m, "*.")
+			if m != base && (d == base || strings.HasSuffix(d, "."+base)) {
+				res.Lists = append(res.Lists, "mdoms.txt")
+				res.Reason = append(res.Reason, fmt.Sprintf("domain matches mask %s", m))
+			}
+		}
+		a.dbMu.RUnlock()
+		if a.ResolverCache != nil {
+			res.Resolved = a.ResolverCache.LookupDomain(d, time.Now(), grace)
+		}
+	} else {
+		http.Error(w, "need ip or domain param", http.StatusBadRequest)
+		return
+	}
+	sort.Strings(res.Lists)
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.Println("LookupHandler", err)
+	}
+}
@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/prgra/rkndaemon/downloader"
+)
+
+// ReloadConfig load and validate config from files and env, then apply it
+func (a *App) ReloadConfig() error {
+	var c Config
+	err := c.Load()
+	if err != nil {
+		return err
+	}
+	return a.Reload(c)
+}
+
+// Reload apply new configuration to running components, parsed data and
+// http listener are kept
+func (a *App) Reload(c Config) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+	old := a.config()
+	if reflect.DeepEqual(old, c) {
+		return nil
+	}
+	var dwn *downloader.Downloader
+	if c.URL != old.URL {
+		dwn, err = downloader.New(c.URL)
+		if err != nil {
+			return err
+		}
+	}
+	if c.ListerHTTP != old.ListerHTTP {
+		log.Println("listen address change need restart, keep", old.ListerHTTP)
+		c.ListerHTTP = old.ListerHTTP
+	}
+	if c.UseDump != old.UseDump || c.UseSoc != old.UseSoc || c.Cron != old.Cron {
+		log.Println("usedump, usesoc and cron changes need restart")
+		c.UseDump, c.UseSoc, c.Cron = old.UseDump, old.UseSoc, old.Cron
+	}
+	if c.StateDir != old.StateDir || c.OutputDir != old.OutputDir {
+		log.Println("statedir and outputdir changes need restart")
+		c.StateDir, c.OutputDir = old.StateDir, old.OutputDir
+	}
+
+	a.mu.Lock()
+	a.Config = c
+	a.Archive = c.archive(a.State)
+	c.configureRetry(a.dumpRetry)
+	c.configureRetry(a.socRetry)
+	if dwn != nil {
+		a.Downloader = dwn
+	}
+	// wake up waiting downloaders to pick new intervals
+	close(a.reloaded)
+	a.reloaded = make(chan struct{})
+	a.mu.Unlock()
+	log.Println("config reloaded")
+	return nil
+}
+
+// WatchConfig reload config when config file modification time changed
+func (a *App) WatchConfig(ctx context.Context, every time.Duration) {
+	fn := ConfigFile()
+	if fn == "" {
+		return
+	}
+	mtime := func() time.Time {
+		st, err := os.Stat(fn)
+		if err != nil {
+			return time.Time{}
+		}
+		return st.ModTime()
+	}
+	last := mtime()
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		m := mtime()
+		if m.Equal(last) {
+			continue
+		}
+		last = m
+		log.Println("config file changed", fn)
+		err := a.ReloadConfig()
+		if err != nil {
+			log.Println("can't reload config, keep old", err)
+		}
+	}
+}
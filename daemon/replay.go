@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prgra/rkndaemon/downloader"
+)
+
+// Replay parse archived dump and social register actual at time t
+// and write outputs to dir
+func (a *App) Replay(ctx context.Context, t time.Time, dir string) error {
+	ar := a.archive()
+	if ar == nil {
+		return fmt.Errorf("archive disabled")
+	}
+	e, err := ar.Find("dump", t)
+	if err != nil {
+		return err
+	}
+	err = a.ReplayFile(ctx, e.Path, dir)
+	if err != nil {
+		return err
+	}
+	se, err := ar.Find("social", t)
+	if err != nil {
+		log.Println("skip social", err)
+		return nil
+	}
+	return a.ReplayFile(ctx, se.Path, dir)
+}
+
+// ReplayFile parse archived zip and write outputs to dir,
+// social archives are detected by name
+func (a *App) ReplayFile(ctx context.Context, zipfn string, dir string) error {
+	log.Println("replay", zipfn)
+	return a.ParseFile(ctx, strings.HasPrefix(filepath.Base(zipfn), "social-"), zipfn, dir)
+}
+
+// ParseFile parse local dump or social register, xml or zip,
+// and write outputs to dir
+func (a *App) ParseFile(ctx context.Context, social bool, fn string, dir string) error {
+	if strings.HasSuffix(strings.ToLower(fn), ".zip") {
+		xr, _, err := downloader.OpenXMLInZip(fn)
+		if err != nil {
+			return err
+		}
+		defer xr.Close()
+		if social {
+			_, err = a.ProcessSocial(xr, fn, dir)
+			return err
+		}
+		return a.ProcessDump(ctx, xr, dir)
+	}
+	if social {
+		_, err := a.ProcessSocialFile(fn, dir)
+		return err
+	}
+	return a.ProcessDumpFile(ctx, fn, dir)
+}
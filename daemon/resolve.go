@@ -0,0 +1,236 @@
+package daemon
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prgra/rkndaemon/metrics"
+	"github.com/prgra/rkndaemon/parser"
+	"github.com/prgra/rkndaemon/resolver"
+)
+
+// refreshTick period of background resolver refresh
+const refreshTick = time.Minute
+
+func init() {
+	metrics.Describe("rkndaemon_resolve_registry_hosts", "gauge", "hosts of registry to resolve")
+	metrics.Describe("rkndaemon_resolve_outdated_hosts", "gauge", "hosts selected by last dump resolve, by state")
+	metrics.Describe("rkndaemon_resolve_pending_hosts", "gauge", "hosts left in current resolve run")
+	metrics.Describe("rkndaemon_resolve_results_total", "counter", "resolved hosts by result")
+	metrics.Describe("rkndaemon_resolve_dropped_total", "counter", "answers dropped by filter by reason")
+	metrics.Describe("rkndaemon_resolve_duration_seconds", "gauge", "duration of last resolve run")
+	metrics.Describe("rkndaemon_resolve_last_run_timestamp_seconds", "gauge", "end time of last resolve run")
+	metrics.Describe("rkndaemon_resolver_cache_domains", "gauge", "domains in resolver cache")
+}
+
+// Resolve new hosts of registry and hosts with answers older than
+// resolvrefresh, the rest is refreshed by ResolveRefresher
+func (a *App) Resolve(ctx context.Context) {
+	cfg := a.config()
+	hosts := a.registryHosts(cfg.SubdomainWords)
+	metrics.Set("rkndaemon_resolve_registry_hosts", float64(len(hosts)))
+	if a.ResolverCache != nil && len(hosts) > 0 {
+		// answers of hosts removed from registry must not get into
+		// resolved outputs and lookup
+		if n := a.ResolverCache.Retain(hosts); n > 0 {
+			log.Printf("drop %d hosts removed from registry from resolver cache", n)
+		}
+	}
+	if a.ResolverCache != nil {
+		unknown, stale := a.ResolverCache.Outdated(hosts, time.Now().Add(-cfg.resolveRefresh()))
+		metrics.Set("rkndaemon_resolve_outdated_hosts", float64(len(unknown)), "state", "new")
+		metrics.Set("rkndaemon_resolve_outdated_hosts", float64(len(stale)), "state", "stale")
+		log.Printf("resolve %d new and %d stale of %d hosts", len(unknown), len(stale), len(hosts))
+		hosts = append(unknown, stale...)
+	}
+	a.resolve(ctx, cfg, hosts, "dump")
+}
+
+// ResolveRefresher resolve oldest hosts in small batches, so every host
+// is refreshed within resolvrefresh without load peaks
+func (a *App) ResolveRefresher(ctx context.Context) {
+	defer a.waitGroup.Done()
+	defer a.running("resolve_refresher")()
+	for {
+		if !sleep(ctx, refreshTick, nil) {
+			return
+		}
+		cfg := a.config()
+		if !cfg.UseResolver || a.ResolverCache == nil {
+			continue
+		}
+		hosts := a.registryHosts(cfg.SubdomainWords)
+		refresh := cfg.resolveRefresh()
+		n := int(float64(len(hosts))*float64(refreshTick)/float64(refresh)) + 1
+		// hosts fresher than half of refresh are left for the next rounds
+		batch := a.ResolverCache.Oldest(hosts, time.Now().Add(-refresh/2), n)
+		if len(batch) == 0 {
+			continue
+		}
+		a.resolve(ctx, cfg, batch, "background")
+	}
+}
+
+// resolve hosts and write resolver outputs, runs are serialized
+func (a *App) resolve(ctx context.Context, cfg Config, hosts []string, run string) {
+	a.resolveMu.Lock()
+	defer a.resolveMu.Unlock()
+	start := time.Now()
+	pool, err := a.resolverPool(cfg)
+	if err != nil {
+		log.Println("can't create resolver", err)
+		return
+	}
+	res, err := cfg.resolver(pool)
+	if err != nil {
+		log.Println("can't create resolver", err)
+		return
+	}
+	if cfg.SubtractSocial {
+		// social resources must not get into resolved.txt
+		res.Filter.Whitelist = append(res.Filter.Whitelist, parser.ParseNets(a.socialNets())...)
+	}
+	a.mu.Lock()
+	a.Resolver = res
+	a.mu.Unlock()
+	cache := a.ResolverCache
+	if cache == nil {
+		// without persistent cache result contains only this run answers
+		cache = resolver.NewCache()
+	}
+	if run == "dump" {
+		log.Printf("start resolving %d hosts on %d workers", len(hosts), cfg.WorkerCount)
+	}
+	pending := len(hosts)
+	for r := range res.Resolve(ctx, hosts) {
+		// empty answers are stored too, so failed hosts are not retried
+		// until they become stale
+		cache.Update(r, time.Now())
+		if len(r.Answers) > 0 {
+			metrics.Add("rkndaemon_resolve_results_total", 1, "result", "ok")
+		} else {
+			metrics.Add("rkndaemon_resolve_results_total", 1, "result", "empty")
+		}
+		for _, d := range r.Dropped {
+			metrics.Add("rkndaemon_resolve_dropped_total", 1, "reason", d.Reason)
+		}
+		pending--
+		metrics.Set("rkndaemon_resolve_pending_hosts", float64(pending), "run", run)
+	}
+	now := time.Now()
+	grace := time.Duration(cfg.ResolverGrace) * time.Hour
+	cache.Prune(now, grace)
+	if ctx.Err() != nil {
+		log.Println("resolving aborted, keep", cfg.resolverFile())
+	} else {
+		resolver.WriteFiles(cache, cfg.resolverFile(), now, grace)
+		if run == "dump" {
+			log.Println("end resolving", time.Since(start).Truncate(time.Millisecond))
+		}
+	}
+	metrics.Set("rkndaemon_resolve_duration_seconds", time.Since(start).Seconds(), "run", run)
+	metrics.Set("rkndaemon_resolve_last_run_timestamp_seconds", float64(time.Now().Unix()), "run", run)
+	if a.ResolverCache != nil {
+		metrics.Set("rkndaemon_resolver_cache_domains", float64(a.ResolverCache.Len()))
+		err := a.ResolverCache.Save(a.State.ResolverCacheFile())
+		if err != nil {
+			log.Println("can't save resolver cache", err)
+		}
+	}
+	// background runs come every minute, hooks are run only after dump
+	if ctx.Err() == nil && run == "dump" {
+		a.fire(ctx, NewEvent(EventResolveDone,
+			"hosts", len(hosts),
+			"resolved_file", cfg.resolverFile(),
+			"cache_domains", cache.Len(),
+		))
+	}
+}
+
+// NewResolver create resolver configured by c with its own upstream pool
+func (c *Config) NewResolver() (*resolver.Resolver, error) {
+	pool, err := resolver.NewPool(c.DNSServers, c.poolOptions())
+	if err != nil {
+		return nil, err
+	}
+	return c.resolver(pool)
+}
+
+// resolver create resolver configured by c for pool
+func (c *Config) resolver(pool *resolver.Pool) (*resolver.Resolver, error) {
+	filter, err := resolver.NewFilter(c.IPWhitelist, c.Sinkholes)
+	if err != nil {
+		return nil, err
+	}
+	res := resolver.New(pool)
+	res.Workers = c.WorkerCount
+	res.Limiter = resolver.NewLimiter(c.ResolverQPS)
+	res.System = c.SystemResolver
+	res.Filter = filter
+	return res, nil
+}
+
+// resolverPool returns upstream pool for cfg, pool is kept between runs
+// to keep upstream health and rebuilt when dns settings are reloaded
+func (a *App) resolverPool(cfg Config) (*resolver.Pool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	opts := cfg.poolOptions()
+	if a.pool != nil && reflect.DeepEqual(a.poolServers, cfg.DNSServers) && a.poolOpts == opts {
+		return a.pool, nil
+	}
+	pool, err := resolver.NewPool(cfg.DNSServers, opts)
+	if err != nil {
+		return nil, err
+	}
+	a.pool, a.poolServers, a.poolOpts = pool, cfg.DNSServers, opts
+	return pool, nil
+}
+
+// registryHosts returns hosts of current registry
+func (a *App) registryHosts(words []string) []string {
+	a.dbMu.RLock()
+	defer a.dbMu.RUnlock()
+	return registryHosts(a.Parser, words)
+}
+
+// registryHosts returns sorted unique hostnames of urls, domains and
+// domain masks, for masks base domain and words subdomains are added
+func registryHosts(db *parser.DB, words []string) []string {
+	hosts := make(map[string]bool)
+	add := func(h string) {
+		h = strings.TrimSuffix(strings.ToLower(h), ".")
+		if h == "" || strings.ContainsAny(h, "* /") {
+			return
+		}
+		hosts[h] = true
+	}
+	for k := range db.URLs {
+		u, err := url.Parse(k)
+		if err != nil {
+			continue
+		}
+		add(u.Hostname())
+	}
+	for k := range db.Domains {
+		add(k)
+	}
+	for k := range db.DomainMasks {
+		base := strings.TrimPrefix(k, "*.")
+		add(base)
+		for _, w := range words {
+			add(w + "." + base)
+		}
+	}
+	res := make([]string, 0, len(hosts))
+	for h := range hosts {
+		res = append(res, h)
+	}
+	sort.Strings(res)
+	return res
+}
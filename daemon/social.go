@@ -0,0 +1,248 @@
+package daemon
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/prgra/rkndaemon/downloader"
+	"github.com/prgra/rkndaemon/metrics"
+	"github.com/prgra/rkndaemon/parser"
+)
+
+// stages of social register cycle
+const (
+	StageDownload  = "download"
+	StageUnmarshal = "unmarshal"
+	StageDecode    = "decode"
+	StageExtract   = "extract"
+	StageParse     = "parse"
+	StageWrite     = "write"
+)
+
+func init() {
+	metrics.Describe("rkndaemon_social_stage_failures_total", "counter", "failed social register cycles by stage")
+	metrics.Describe("rkndaemon_social_last_success_timestamp_seconds", "gauge", "time of last complete social register cycle")
+}
+
+// StageError error of social register cycle stage, cycle is aborted
+// and outputs of previous cycle are kept
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("social %s: %v", e.Stage, e.Err)
+}
+
+// Unwrap returns underlying error
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// stageError wrap err of stage, answer and file errors are parse
+// errors for retry policy
+func stageError(stage string, err error) error {
+	if stage != StageDownload && stage != StageWrite {
+		err = downloader.ParseError(err)
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+// SocialFailure last failed social cycle
+type SocialFailure struct {
+	Stage string    `json:"stage"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// updateSocial run one social register cycle: download, unmarshal,
+// decode, extract, parse and write, any failed stage aborts the cycle
+func (a *App) updateSocial(ctx context.Context) error {
+	err := a.socialCycle(ctx)
+	var se *StageError
+	if errors.As(err, &se) && ctx.Err() == nil {
+		metrics.Add("rkndaemon_social_stage_failures_total", 1, "stage", se.Stage)
+		a.mu.Lock()
+		a.socFailure = &SocialFailure{Stage: se.Stage, Error: se.Err.Error(), Time: time.Now()}
+		a.mu.Unlock()
+	}
+	if err == nil {
+		metrics.Set("rkndaemon_social_last_success_timestamp_seconds", float64(time.Now().Unix()))
+		a.mu.Lock()
+		a.socFailure = nil
+		a.mu.Unlock()
+	}
+	return err
+}
+
+func (a *App) socialCycle(ctx context.Context) error {
+	cfg := a.config()
+	zfn := a.State.ZipFile("social")
+	hash, _, err := a.downloader().DownloadZip(ctx, "getResultSocResources", zfn)
+	if err != nil {
+		var ce base64.CorruptInputError
+		switch {
+		case errors.As(err, &ce):
+			return &StageError{Stage: StageDecode, Err: err}
+		case downloader.Classify(err) == downloader.KindParse:
+			return &StageError{Stage: StageUnmarshal, Err: err}
+		}
+		return stageError(StageDownload, err)
+	}
+	ss := a.socialState()
+	ss.Checked = time.Now()
+	_, serr := os.Stat(filepath.Join(cfg.OutputDir, parser.SocRecordsFile))
+	if hash == ss.Hash && serr == nil {
+		log.Println("social register not changed since", ss.Changed.Format(time.RFC3339))
+		a.setSocialState(ss)
+		return nil
+	}
+	if ar := a.archive(); ar != nil {
+		_, err = ar.SaveFile("social", time.Now(), zfn)
+		if err != nil {
+			log.Println("can't archive social", err)
+		}
+	}
+	xr, fn, err := a.openXML(zfn, cfg.SaveXML)
+	if err != nil {
+		return stageError(StageExtract, err)
+	}
+	diff, err := a.ProcessSocial(xr, fn, cfg.OutputDir)
+	xr.Close()
+	if err != nil {
+		return err
+	}
+	ss.Hash = hash
+	if !diff.Empty() {
+		ss.Changed = ss.Checked
+	}
+	a.setSocialState(ss)
+	if diff.Empty() {
+		log.Println("social records not changed, skip social-updated hooks")
+		return nil
+	}
+	a.dbMu.RLock()
+	records := len(a.Parser.SocRecords)
+	a.dbMu.RUnlock()
+	a.fire(ctx, NewEvent(EventSocialUpdated,
+		"records", records,
+		"added", len(diff.Added),
+		"removed", len(diff.Removed),
+		"changed", len(diff.Changed),
+		"records_file", filepath.Join(cfg.OutputDir, parser.SocRecordsFile),
+		"changes_file", filepath.Join(cfg.OutputDir, parser.SocChangesFile),
+	))
+	return nil
+}
+
+// ProcessSocialFile parse social xml and write outputs to dir
+func (a *App) ProcessSocialFile(fn string, dir string) (parser.SocDiff, error) {
+	xmlFile, err := os.Open(path.Clean(fn))
+	if err != nil {
+		return parser.SocDiff{}, stageError(StageParse, err)
+	}
+	defer xmlFile.Close()
+	return a.ProcessSocial(xmlFile, fn, dir)
+}
+
+// ProcessSocial parse social xml from r and write outputs to dir, changes
+// against records previously written to dir go to SocChanges.json.
+// Parsed register replaces current one only after outputs are written
+func (a *App) ProcessSocial(r io.Reader, name string, dir string) (parser.SocDiff, error) {
+	old, err := parser.LoadSocRecords(filepath.Join(dir, parser.SocRecordsFile))
+	if err != nil {
+		log.Println("can't load previous social records", err)
+	}
+	db, err := readSocial(r, name)
+	if err != nil {
+		return parser.SocDiff{}, stageError(StageParse, err)
+	}
+	diff := parser.DiffSoc(old, db.SocRecords)
+	err = db.WriteSocialFiles(dir, diff)
+	if err != nil {
+		return diff, stageError(StageWrite, err)
+	}
+	log.Printf("social resources added %d, removed %d, changed %d", len(diff.Added), len(diff.Removed), len(diff.Changed))
+	a.dbMu.Lock()
+	a.Parser.SocNets = db.SocNets
+	a.Parser.SocDomains = db.SocDomains
+	a.Parser.SocRecords = db.SocRecords
+	dumpParsed := len(a.Parser.URLs) > 0 || len(a.Parser.Domains) > 0 || len(a.Parser.BlockedIPs) > 0
+	a.dbMu.Unlock()
+	a.mu.Lock()
+	a.socDiff = diff
+	a.mu.Unlock()
+	if dumpParsed && a.config().SubtractSocial {
+		// ip outputs depend on social subnets
+		err = a.writeDumpFiles(dir)
+		if err != nil {
+			return diff, stageError(StageWrite, err)
+		}
+	}
+	if dumpParsed {
+		// without dump lists conflicts of previous run would be lost
+		a.checkConflicts(dir)
+	}
+	return diff, nil
+}
+
+// readSocial parse social xml, register without records is an error
+// so broken file does not replace good outputs
+func readSocial(r io.Reader, name string) (*parser.DB, error) {
+	log.Println("start read social")
+	var err error
+	db := parser.NewDB()
+	xmlDec := xml.NewDecoder(r)
+	for {
+		t, xerr := xmlDec.Token()
+		if xerr == io.EOF {
+			break
+		}
+		if xerr != nil {
+			// broken stream must not look like end of register
+			return nil, xerr
+		}
+		switch se := t.(type) {
+		case xml.StartElement:
+			if se.Name.Local != "content" {
+				continue
+			}
+			var item parser.SocRecord
+			err = xmlDec.DecodeElement(&item, &se)
+			if err != nil {
+				return nil, err
+			}
+			for i := range se.Attr {
+				// id="2" hash="ffdb3ec46de4883efd3c1ca99d7c0ee0" includeTime="2022-01-26T22:00:00+03:00"
+
+				if se.Attr[i].Name.Local == "id" {
+					item.ID, _ = strconv.Atoi(se.Attr[i].Value)
+				}
+				if se.Attr[i].Name.Local == "hash" {
+					item.Hash = se.Attr[i].Value
+				}
+				if se.Attr[i].Name.Local == "includeTime" {
+					item.IncludeTime, _ = time.Parse("2006-01-02T15:04:05-07:00", se.Attr[i].Value)
+
+				}
+			}
+			db.ParseSoc(item)
+		}
+	}
+	if len(db.SocRecords) == 0 {
+		return nil, fmt.Errorf("no records in %s", name)
+	}
+	log.Println("end read social file")
+	return db, nil
+}
@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prgra/rkndaemon/downloader"
+	"github.com/prgra/rkndaemon/resolver"
+)
+
+// Status of daemon for status api
+type Status struct {
+	DumpDate  time.Time                        `json:"dump_date,omitempty"`
+	Dump      DumpWatch                        `json:"dump"`
+	Social    SocialStatus                     `json:"social"`
+	Conflicts int                              `json:"conflicts"`
+	Downloads map[string]downloader.RetryState `json:"downloads"`
+	Upstreams []resolver.ServerStats           `json:"upstreams,omitempty"`
+}
+
+// SocialStatus social register size and last changes
+type SocialStatus struct {
+	LastChecked time.Time `json:"last_checked"`
+	LastChanged time.Time `json:"last_changed"`
+	Records     int       `json:"records"`
+	Added       int       `json:"added"`
+	Removed     int       `json:"removed"`
+	Changed     int       `json:"changed"`
+	// LastFailure is set while last cycle failed, outputs are from
+	// previous successful cycle
+	LastFailure *SocialFailure `json:"last_failure,omitempty"`
+}
+
+// Status returns current daemon status
+func (a *App) Status() Status {
+	var st Status
+	if dd, err := a.State.LoadDumpDate(); err == nil && dd > 0 {
+		st.DumpDate = time.Unix(int64(dd/1000), 0)
+	}
+	st.Dump = a.DumpWatch(time.Now())
+	st.Downloads = map[string]downloader.RetryState{
+		"dump":   a.dumpRetry.State(),
+		"social": a.socRetry.State(),
+	}
+	a.mu.RLock()
+	pool := a.pool
+	diff := a.socDiff
+	ss := a.social
+	failure := a.socFailure
+	st.Conflicts = a.conflicts
+	a.mu.RUnlock()
+	st.Social = SocialStatus{
+		LastChecked: ss.Checked,
+		LastChanged: ss.Changed,
+		Added:       len(diff.Added),
+		Removed:     len(diff.Removed),
+		Changed:     len(diff.Changed),
+		LastFailure: failure,
+	}
+	a.dbMu.RLock()
+	st.Social.Records = len(a.Parser.SocRecords)
+	a.dbMu.RUnlock()
+	if pool != nil {
+		st.Upstreams = pool.Stats()
+	}
+	return st
+}
+
+// StatusHandler write Status as json, /api/status
+func (a *App) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(a.Status())
+	if err != nil {
+		log.Println("StatusHandler", err)
+	}
+}
@@ -0,0 +1,222 @@
+package daemon
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prgra/rkndaemon/downloader"
+	"github.com/prgra/rkndaemon/parser"
+)
+
+// zip corruptions
+const (
+	zipOK = iota
+	// zipDeflate changes compressed data, reader fails with flate error
+	zipDeflate
+	// zipChecksum changes letters of stored file, xml stays valid and
+	// reader fails with checksum error at the end
+	zipChecksum
+)
+
+// writeZip write xml as dump.xml into zip file, damaged as corrupt says
+func writeZip(t *testing.T, xml []byte, corrupt int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fh := &zip.FileHeader{Name: "dump.xml", Method: zip.Deflate}
+	if corrupt == zipChecksum {
+		fh.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(xml) // nolint
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	mid := len(b) / 2
+	for i := mid; i < mid+64; i++ {
+		switch {
+		case corrupt == zipDeflate:
+			b[i] ^= 0x55
+		case corrupt == zipChecksum && b[i] >= 'a' && b[i] < 'z':
+			b[i]++
+		}
+	}
+	fn := filepath.Join(t.TempDir(), "dump.zip")
+	err = os.WriteFile(fn, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func dumpXML(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<reg:register xmlns:reg="http://rsoc.ru">` + "\n")
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `<content id="%d" entryType="1" blockType="domain"><url><![CDATA[https://h%d.example/%d]]></url><domain><![CDATA[h%d.example]]></domain><ip>10.%d.%d.%d</ip></content>`+"\n",
+			i, i, i*7919, i, i>>16&255, i>>8&255, i&255)
+	}
+	b.WriteString("</reg:register>\n")
+	return b.Bytes()
+}
+
+func TestReadDumpZip(t *testing.T) {
+	a, err := NewOffline(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xr, _, err := downloader.OpenXMLInZip(writeZip(t, dumpXML(20000), zipOK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.ReadDump(context.Background(), xr)
+	xr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Parser.Domains) != 20000 {
+		t.Fatalf("got %d domains, want 20000", len(a.Parser.Domains))
+	}
+}
+
+func TestReadDumpCorruptedZip(t *testing.T) {
+	for _, corrupt := range []int{zipDeflate, zipChecksum} {
+		a, err := NewOffline(Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		xr, _, err := downloader.OpenXMLInZip(writeZip(t, dumpXML(100), zipOK))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = a.ReadDump(context.Background(), xr)
+		xr.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		xr, _, err = downloader.OpenXMLInZip(writeZip(t, dumpXML(20000), corrupt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = a.ReadDump(context.Background(), xr)
+		xr.Close()
+		if err == nil {
+			t.Fatalf("corruption %d: corrupted zip read without error", corrupt)
+		}
+		if len(a.Parser.Domains) != 100 {
+			t.Fatalf("corruption %d: lists replaced by partial dump, got %d domains, want 100", corrupt, len(a.Parser.Domains))
+		}
+	}
+}
+
+func socialXML(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<registerSocResources>` + "\n")
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `<content id="%d" hash="%032x" includeTime="2022-01-26T22:00:00+03:00"><resourceName>r%d</resourceName><domain>s%d.example</domain><ipSubnet>10.%d.%d.0/24</ipSubnet></content>`+"\n",
+			i, i*7919, i, i, i>>8&255, i&255)
+	}
+	b.WriteString("</registerSocResources>\n")
+	return b.Bytes()
+}
+
+func TestProcessSocialCorruptedZip(t *testing.T) {
+	for _, corrupt := range []int{zipDeflate, zipChecksum} {
+		dir := t.TempDir()
+		a, err := NewOffline(Config{OutputDir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		xr, _, err := downloader.OpenXMLInZip(writeZip(t, socialXML(100), zipOK))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = a.ProcessSocial(xr, "social.xml", dir)
+		xr.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, err := os.ReadFile(filepath.Join(dir, parser.SocRecordsFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		xr, _, err = downloader.OpenXMLInZip(writeZip(t, socialXML(20000), corrupt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = a.ProcessSocial(xr, "social.xml", dir)
+		xr.Close()
+		var se *StageError
+		if !errors.As(err, &se) || se.Stage != StageParse {
+			t.Fatalf("corruption %d: got error %v, want %s stage error", corrupt, err, StageParse)
+		}
+		after, _ := os.ReadFile(filepath.Join(dir, parser.SocRecordsFile))
+		if !bytes.Equal(before, after) || len(a.Parser.SocRecords) != 100 {
+			t.Fatalf("corruption %d: previous social outputs replaced, %d records", corrupt, len(a.Parser.SocRecords))
+		}
+	}
+}
+
+func TestProcessSocialWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewOffline(Config{OutputDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.ProcessSocial(bytes.NewReader(socialXML(10)), "social.xml", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(filepath.Join(dir, parser.SocRecordsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// file in place of sets directory breaks moving of written files
+	sdir := filepath.Join(dir, parser.SocSetsDir)
+	err = os.RemoveAll(sdir)
+	if err == nil {
+		err = os.WriteFile(sdir, nil, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.ProcessSocial(bytes.NewReader(socialXML(20)), "social.xml", dir)
+	var se *StageError
+	if !errors.As(err, &se) || se.Stage != StageWrite {
+		t.Fatalf("got error %v, want %s stage error", err, StageWrite)
+	}
+	after, _ := os.ReadFile(filepath.Join(dir, parser.SocRecordsFile))
+	if !bytes.Equal(before, after) || len(a.Parser.SocRecords) != 10 {
+		t.Errorf("records replaced after failed write, %d records", len(a.Parser.SocRecords))
+	}
+	tmps, _ := filepath.Glob(filepath.Join(dir, ".social.*"))
+	if len(tmps) != 0 {
+		t.Errorf("temporary files left: %v", tmps)
+	}
+
+	os.Remove(sdir) // nolint
+	diff, err := a.ProcessSocial(bytes.NewReader(socialXML(20)), "social.xml", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added) != 10 {
+		t.Errorf("added %d after failed write, want 10", len(diff.Added))
+	}
+	var changes parser.SocDiff
+	b, _ := os.ReadFile(filepath.Join(dir, parser.SocChangesFile))
+	if json.Unmarshal(b, &changes) != nil || len(changes.Added) != 10 {
+		t.Errorf("%s does not match records: %s", parser.SocChangesFile, b)
+	}
+}
@@ -0,0 +1,162 @@
+package daemon
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prgra/rkndaemon/metrics"
+)
+
+// stale alert levels
+const (
+	StaleOK       = "ok"
+	StaleWarning  = "warning"
+	StaleCritical = "critical"
+)
+
+// watchTick period of staleness checks
+const watchTick = time.Minute
+
+func init() {
+	metrics.Describe("rkndaemon_dump_age_seconds", "gauge", "time since applied dump was last confirmed as latest")
+	metrics.Describe("rkndaemon_dump_behind_seconds", "gauge", "latest dump date of registry minus applied dump date")
+	metrics.Describe("rkndaemon_dump_stale_level", "gauge", "dump staleness alert, 0 ok, 1 warning, 2 critical")
+}
+
+// DumpWatch applied and latest known dump dates, Synced is last time
+// applied dump was known to be the latest one
+type DumpWatch struct {
+	Applied time.Time `json:"applied,omitempty"`
+	Latest  time.Time `json:"latest,omitempty"`
+	Checked time.Time `json:"checked,omitempty"`
+	Synced  time.Time `json:"synced,omitempty"`
+	Age     float64   `json:"age_seconds"`
+	Alert   string    `json:"alert"`
+}
+
+// dumpTime convert registry dump date in milliseconds
+func dumpTime(ms int) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(ms/1000), 0)
+}
+
+// initWatch start watch from saved dump date, until first check applied
+// dump is assumed to be latest since it was last confirmed, without dump
+// age is counted from start
+func (a *App) initWatch(dd int) {
+	synced := a.State.DumpSynced()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.watch.Applied = dumpTime(dd)
+	a.watch.Latest = a.watch.Applied
+	a.watch.Synced = time.Now()
+	if dd > 0 && !synced.IsZero() {
+		a.watch.Synced = synced
+	}
+}
+
+// watchChecked remember latest dump date returned by registry
+func (a *App) watchChecked(latest int) {
+	a.mu.Lock()
+	a.watch.Checked = time.Now()
+	a.watch.Latest = dumpTime(latest)
+	synced := !a.watch.Latest.After(a.watch.Applied)
+	if synced {
+		a.watch.Synced = a.watch.Checked
+	}
+	t := a.watch.Synced
+	a.mu.Unlock()
+	if synced {
+		a.saveSynced(t)
+	}
+}
+
+// watchApplied remember applied dump date
+func (a *App) watchApplied(dd int) {
+	a.mu.Lock()
+	a.watch.Applied = dumpTime(dd)
+	synced := !a.watch.Latest.After(a.watch.Applied)
+	if synced {
+		a.watch.Synced = time.Now()
+	}
+	t := a.watch.Synced
+	a.mu.Unlock()
+	if synced {
+		a.saveSynced(t)
+	}
+}
+
+// saveSynced persist sync time for check without running daemon and
+// for next start
+func (a *App) saveSynced(t time.Time) {
+	err := a.State.TouchDumpSynced(t)
+	if err != nil {
+		log.Println("can't save dump sync time", err)
+	}
+}
+
+// DumpWatch returns dump staleness at now
+func (a *App) DumpWatch(now time.Time) DumpWatch {
+	a.mu.RLock()
+	w := a.watch
+	cfg := a.Config
+	a.mu.RUnlock()
+	w.Alert = StaleOK
+	if w.Synced.IsZero() {
+		return w
+	}
+	age := now.Sub(w.Synced)
+	w.Age = age.Truncate(time.Second).Seconds()
+	switch {
+	case cfg.StaleCritical > 0 && age >= time.Duration(cfg.StaleCritical)*time.Minute:
+		w.Alert = StaleCritical
+	case cfg.StaleWarning > 0 && age >= time.Duration(cfg.StaleWarning)*time.Minute:
+		w.Alert = StaleWarning
+	}
+	return w
+}
+
+// Watchdog check dump staleness every minute, dump-stale event is fired
+// when alert level changes, also when it returns to ok
+func (a *App) Watchdog(ctx context.Context) {
+	defer a.waitGroup.Done()
+	defer a.running("watchdog")()
+	level := StaleOK
+	for {
+		w := a.DumpWatch(time.Now())
+		metrics.Set("rkndaemon_dump_age_seconds", w.Age)
+		if !w.Latest.IsZero() {
+			metrics.Set("rkndaemon_dump_behind_seconds", w.Latest.Sub(w.Applied).Seconds())
+		}
+		metrics.Set("rkndaemon_dump_stale_level", float64(staleLevel(w.Alert)))
+		if w.Alert != level {
+			log.Printf("dump staleness %s -> %s, applied %s, latest %s, age %s", level, w.Alert,
+				w.Applied.Format(time.RFC3339), w.Latest.Format(time.RFC3339), time.Duration(w.Age)*time.Second)
+			a.fire(ctx, NewEvent(EventDumpStale,
+				"alert", w.Alert,
+				"previous", level,
+				"age", int64(w.Age),
+				"applied", w.Applied,
+				"latest", w.Latest,
+				"checked", w.Checked,
+			))
+			level = w.Alert
+		}
+		if !sleep(ctx, watchTick, nil) {
+			return
+		}
+	}
+}
+
+func staleLevel(alert string) int {
+	switch alert {
+	case StaleWarning:
+		return 1
+	case StaleCritical:
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,182 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prgra/rkndaemon/metrics"
+)
+
+// defaultWebhookTimeout used when webhook has no timeout
+const defaultWebhookTimeout = 10 * time.Second
+
+// defaultWebhookFailures download failures in a row before
+// download-failed is sent
+const defaultWebhookFailures = 3
+
+// webhookRetryDelay first pause between webhook retries, doubles on
+// every retry
+var webhookRetryDelay = time.Second
+
+// defaultWebhookEvents sent to webhook without events, resolve-finished
+// is left out as it comes with every background refresh
+var defaultWebhookEvents = []string{EventDumpUpdated, EventSocialUpdated, EventDownloadFailed}
+
+func init() {
+	metrics.Describe("rkndaemon_webhook_sends_total", "counter", "webhook deliveries by host, event and result")
+}
+
+// Webhook receives events as json POST, toml:
+//
+//	[[webhooks]]
+//	endpoint = "https://noc.example.com/rkn"
+//	secret = "key" # X-RKN-Signature: sha256=hex(hmac_sha256(secret, body))
+//	events = ["dump-updated", "download-failed"] # empty means dump-updated, social-updated, download-failed
+//	timeout = 10 # seconds
+//	retries = 3
+//	failures = 3 # download failures in a row before download-failed
+type Webhook struct {
+	Endpoint string
+	Secret   string
+	Events   []string
+	Timeout  int64
+	Retries  int64
+	Failures int64
+}
+
+// WebhookPayload json body of webhook
+type WebhookPayload struct {
+	Event string                 `json:"event"`
+	Time  time.Time              `json:"time"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// validate check webhook url and events
+func (w Webhook) validate() error {
+	u, err := url.Parse(w.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("bad webhook endpoint %q", w.Endpoint)
+	}
+	for _, e := range w.Events {
+		if err := (Hook{Event: e, Command: "true"}).validateEvent(); err != nil {
+			return fmt.Errorf("webhook %s: %w", u.Host, err)
+		}
+	}
+	if w.Timeout < 0 || w.Retries < 0 || w.Failures < 0 {
+		return fmt.Errorf("webhook %s: timeout, retries and failures can't be negative", u.Host)
+	}
+	return nil
+}
+
+// wants webhook subscribed to event, download failures are sent only
+// after Failures in a row
+func (w Webhook) wants(e Event) bool {
+	if e.Name == EventDownloadFailed {
+		min := w.Failures
+		if min == 0 {
+			min = defaultWebhookFailures
+		}
+		if n, ok := e.Data["failures"].(int); ok && int64(n) < min {
+			return false
+		}
+	}
+	events := w.Events
+	if len(events) == 0 {
+		events = defaultWebhookEvents
+	}
+	for _, name := range events {
+		if name == e.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns signature of body for X-RKN-Signature header
+func Sign(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body) // nolint
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// sendWebhooks send event to subscribed webhooks in background
+func (a *App) sendWebhooks(ctx context.Context, whs []Webhook, e Event) {
+	body, err := json.Marshal(WebhookPayload{Event: e.Name, Time: e.Time, Data: e.Data})
+	if err != nil {
+		log.Println("webhook payload", err)
+		return
+	}
+	for _, w := range whs {
+		if !w.wants(e) {
+			continue
+		}
+		a.webhookWG.Add(1)
+		go func(w Webhook) {
+			defer a.webhookWG.Done()
+			a.sendWebhook(ctx, w, e.Name, body)
+		}(w)
+	}
+}
+
+// sendWebhook post body with retries, delay doubles from webhookRetryDelay
+func (a *App) sendWebhook(ctx context.Context, w Webhook, event string, body []byte) {
+	timeout := time.Duration(w.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+	host := w.Endpoint
+	if u, err := url.Parse(w.Endpoint); err == nil {
+		host = u.Host
+	}
+	delay := webhookRetryDelay
+	for try := int64(0); try <= w.Retries; try++ {
+		if try > 0 {
+			if !sleep(ctx, delay, nil) {
+				return
+			}
+			delay *= 2
+		}
+		err := postWebhook(ctx, w, event, body, timeout)
+		if err == nil {
+			metrics.Add("rkndaemon_webhook_sends_total", 1, "host", host, "event", event, "result", "ok")
+			return
+		}
+		metrics.Add("rkndaemon_webhook_sends_total", 1, "host", host, "event", event, "result", "fail")
+		log.Printf("webhook %s %s (try %d of %d): %v", host, event, try+1, w.Retries+1, err)
+	}
+}
+
+func postWebhook(ctx context.Context, w Webhook, event string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rkndaemon")
+	req.Header.Set("X-RKN-Event", event)
+	if w.Secret != "" {
+		req.Header.Set("X-RKN-Signature", Sign(w.Secret, body))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16)) // nolint
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package daemon
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookServer records webhook requests, answers with codes in order and
+// 200 after them
+type hookServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	codes []int
+	reqs  []hookRequest
+}
+
+type hookRequest struct {
+	time      time.Time
+	path      string
+	event     string
+	signature string
+	body      []byte
+}
+
+func newHookServer(codes ...int) *hookServer {
+	s := &hookServer{codes: codes}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.reqs = append(s.reqs, hookRequest{
+			time:      time.Now(),
+			path:      r.URL.Path,
+			event:     r.Header.Get("X-RKN-Event"),
+			signature: r.Header.Get("X-RKN-Signature"),
+			body:      body,
+		})
+		code := http.StatusOK
+		if len(s.codes) > 0 {
+			code, s.codes = s.codes[0], s.codes[1:]
+		}
+		s.mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	return s
+}
+
+func (s *hookServer) requests() []hookRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]hookRequest(nil), s.reqs...)
+}
+
+// send event to webhooks and wait for delivery
+func send(whs []Webhook, e Event) {
+	a := &App{}
+	a.sendWebhooks(context.Background(), whs, e)
+	a.webhookWG.Wait()
+}
+
+func TestWebhookSignature(t *testing.T) {
+	srv := newHookServer()
+	defer srv.Close()
+	e := NewEvent(EventDumpUpdated, "dump_date", int64(1700000000), "added", 3)
+	send([]Webhook{
+		{Endpoint: srv.URL, Secret: "key"},
+		{Endpoint: srv.URL},
+	}, e)
+	reqs := srv.requests()
+	if len(reqs) != 2 {
+		t.Fatalf("%d requests, want 2", len(reqs))
+	}
+	signed := 0
+	for _, r := range reqs {
+		if r.event != EventDumpUpdated {
+			t.Errorf("X-RKN-Event %q", r.event)
+		}
+		var p WebhookPayload
+		if err := json.Unmarshal(r.body, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Event != EventDumpUpdated || p.Data["added"] != float64(3) {
+			t.Errorf("payload %+v", p)
+		}
+		if r.signature == "" {
+			continue
+		}
+		signed++
+		m := hmac.New(sha256.New, []byte("key"))
+		m.Write(r.body) // nolint
+		if want := "sha256=" + hex.EncodeToString(m.Sum(nil)); r.signature != want {
+			t.Errorf("X-RKN-Signature %s, want %s", r.signature, want)
+		}
+	}
+	if signed != 1 {
+		t.Errorf("%d signed requests, want 1", signed)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = 20 * time.Millisecond
+
+	// succeeds on third try
+	srv := newHookServer(500, 404)
+	defer srv.Close()
+	send([]Webhook{{Endpoint: srv.URL, Retries: 3}}, NewEvent(EventSocialUpdated))
+	reqs := srv.requests()
+	if len(reqs) != 3 {
+		t.Fatalf("%d requests, want 3", len(reqs))
+	}
+	for i := 1; i < len(reqs); i++ {
+		gap := reqs[i].time.Sub(reqs[i-1].time)
+		if min := webhookRetryDelay << (i - 1); gap < min {
+			t.Errorf("pause before try %d %s, want at least %s", i+1, gap, min)
+		}
+	}
+
+	// gives up after retries
+	bad := newHookServer(500, 502, 503, 504, 500)
+	defer bad.Close()
+	send([]Webhook{{Endpoint: bad.URL, Retries: 2}}, NewEvent(EventSocialUpdated))
+	if n := len(bad.requests()); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestWebhookFailures(t *testing.T) {
+	srv := newHookServer()
+	defer srv.Close()
+	whs := []Webhook{
+		{Endpoint: srv.URL + "/default"},
+		{Endpoint: srv.URL + "/one", Failures: 1},
+	}
+	for n := 1; n <= 4; n++ {
+		send(whs, NewEvent(EventDownloadFailed, "source", "dump", "failures", n))
+	}
+	paths := make(map[string]int)
+	for _, r := range srv.requests() {
+		if r.event != EventDownloadFailed {
+			t.Errorf("X-RKN-Event %q", r.event)
+		}
+		paths[r.path]++
+	}
+	// default threshold 3 passes failures 3 and 4, threshold 1 all four
+	if paths["/default"] != 2 || paths["/one"] != 4 {
+		t.Errorf("sent %v, want /default 2 and /one 4", paths)
+	}
+
+	wh := Webhook{Endpoint: srv.URL}
+	for _, tt := range []struct {
+		e    Event
+		want bool
+	}{
+		{NewEvent(EventDumpUpdated), true},
+		{NewEvent(EventSocialUpdated), true},
+		{NewEvent(EventResolveDone), false},
+		{NewEvent(EventDumpStale), false},
+		{NewEvent(EventDownloadFailed, "failures", 2), false},
+		{NewEvent(EventDownloadFailed, "failures", 3), true},
+	} {
+		if got := wh.wants(tt.e); got != tt.want {
+			t.Errorf("default webhook wants %s %v: %v", tt.e.Name, tt.e.Data, got)
+		}
+	}
+	wh.Events = []string{EventResolveDone}
+	if !wh.wants(NewEvent(EventResolveDone)) || wh.wants(NewEvent(EventDumpUpdated)) {
+		t.Error("explicit events are not respected")
+	}
+}
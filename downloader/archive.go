@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveTimeFormat used in archive file names, always UTC
+const archiveTimeFormat = "20060102T150405Z"
+
+// Archive keeps downloaded zip archives with retention,
+// files are named <kind>-<time>.zip
+type Archive struct {
+	Dir      string
+	MaxCount int
+	MaxAge   time.Duration
+	MaxSize  int64
+}
+
+// ArchiveEntry is one archived zip
+type ArchiveEntry struct {
+	Kind string
+	Time time.Time
+	Path string
+	Size int64
+}
+
+// SaveFile store zip file src of kind for time t and apply retention
+func (ar *Archive) SaveFile(kind string, t time.Time, src string) (string, error) {
+	fn := filepath.Join(ar.Dir, fmt.Sprintf("%s-%s.zip", kind, t.UTC().Format(archiveTimeFormat)))
+	err := copyFile(fn, src)
+	if err != nil {
+		return "", err
+	}
+	err = ar.Prune(kind)
+	if err != nil {
+		log.Println("archive prune", err)
+	}
+	return fn, nil
+}
+
+// List archived entries of kind, newest first
+func (ar *Archive) List(kind string) ([]ArchiveEntry, error) {
+	des, err := os.ReadDir(ar.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []ArchiveEntry
+	for _, de := range des {
+		name := de.Name()
+		if de.IsDir() || !strings.HasPrefix(name, kind+"-") || !strings.HasSuffix(name, ".zip") {
+			continue
+		}
+		t, err := time.Parse(archiveTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, kind+"-"), ".zip"))
+		if err != nil {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		res = append(res, ArchiveEntry{
+			Kind: kind,
+			Time: t,
+			Path: filepath.Join(ar.Dir, name),
+			Size: fi.Size(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Time.After(res[j].Time)
+	})
+	return res, nil
+}
+
+// Find newest entry of kind which was actual at time t
+func (ar *Archive) Find(kind string, t time.Time) (ArchiveEntry, error) {
+	list, err := ar.List(kind)
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+	for i := range list {
+		if !list[i].Time.After(t) {
+			return list[i], nil
+		}
+	}
+	return ArchiveEntry{}, fmt.Errorf("no %s archive at %s", kind, t.Format(time.RFC3339))
+}
+
+// Prune remove entries of kind over MaxCount, older than MaxAge or
+// over MaxSize in total, newest entry is always kept
+func (ar *Archive) Prune(kind string) error {
+	list, err := ar.List(kind)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for i := range list {
+		total += list[i].Size
+		if i == 0 {
+			continue
+		}
+		if (ar.MaxCount > 0 && i >= ar.MaxCount) ||
+			(ar.MaxAge > 0 && time.Since(list[i].Time) > ar.MaxAge) ||
+			(ar.MaxSize > 0 && total > ar.MaxSize) {
+			err = os.Remove(list[i].Path)
+			if err != nil {
+				return err
+			}
+			log.Println("archive removed", list[i].Path)
+		}
+	}
+	return nil
+}
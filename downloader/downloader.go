@@ -2,22 +2,26 @@ package downloader
 
 import (
 	"archive/zip"
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
-	"strconv"
+	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/prgra/rkndaemon/atomicfile"
 	"github.com/tiaguinho/gosoap"
 )
 
 type Downloader struct {
 	SOAP *gosoap.Client
+	wsdl string
+	mu   sync.Mutex
 }
 
 func New(endpoint string) (*Downloader, error) {
@@ -37,61 +41,92 @@ func New(endpoint string) (*Downloader, error) {
 	soap.Password, _ = u.User.Password()
 	return &Downloader{
 		SOAP: soap,
+		wsdl: endpoint,
 	}, nil
 
 }
 
+// Call soap method m, returns ctx error if ctx canceled before answer
+func (d *Downloader) Call(ctx context.Context, m string, p gosoap.SoapParams) (*gosoap.Response, error) {
+	type result struct {
+		res *gosoap.Response
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		res, err := d.SOAP.Call(m, p)
+		ch <- result{res, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.res, r.err
+	}
+}
+
 type GetdateRes struct {
 	Date int `xml:"lastDumpDate"`
 }
 
 type Resp struct {
-	Result bool   `xml:"result"`
-	Zip    []byte `xml:"registerZipArchive"`
+	Result        bool   `xml:"result"`
+	ResultComment string `xml:"resultComment"`
 }
 
-func FindXMLInZipAndSave(b []byte) (fn string, err error) {
-	zipReader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
-	if err != nil {
-		return "", err
+// Err returns error of answer without archive, auth errors are
+// recognized by comment
+func (r *Resp) Err() error {
+	if r.Result {
+		return nil
+	}
+	err := fmt.Errorf("no archive in answer: %s", r.ResultComment)
+	if Classify(err) == KindAuth {
+		return &Error{Kind: KindAuth, Err: err}
 	}
+	return err
+}
+
+// extractXML extract xml files from zip into dir, directories from zip
+// names are dropped, returns path of last extracted file
+func extractXML(zipReader *zip.Reader, dir string) (fn string, err error) {
 	t := time.Now()
 	for _, zipFile := range zipReader.File {
-		if err != nil {
-			log.Println(err)
+		name, ok := safeName(zipFile.Name)
+		if !ok || !strings.HasSuffix(name, ".xml") {
 			continue
 		}
-		if strings.HasSuffix(zipFile.Name, ".xml") {
-			fn = zipFile.Name
-			log.Println("found xml file", fn, ByteCountIEC(int64(zipFile.UncompressedSize64)))
-			f, err := os.Create(fn)
-			if err != nil {
-				return "", err
-			}
-			unzippedFileBytes, err := readZipFile(zipFile)
-			if err != nil {
-				return "", err
-
-			}
-			r := bytes.NewReader(unzippedFileBytes)
-			_, err = io.Copy(f, r)
+		fn = filepath.Join(dir, name)
+		log.Println("found xml file", zipFile.Name, ByteCountIEC(int64(zipFile.UncompressedSize64)))
+		err = atomicfile.Write(fn, func(w io.Writer) error {
+			f, err := zipFile.Open()
 			if err != nil {
-				return "", err
+				return err
 			}
-			f.Close()
-			log.Println("file extracted, time", time.Since(t).Truncate(time.Millisecond))
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		})
+		if err != nil {
+			return "", err
 		}
+		log.Println("file extracted to", fn, "time", time.Since(t).Truncate(time.Millisecond))
+	}
+	if fn == "" {
+		return "", fmt.Errorf("no xml file in zip")
 	}
 	return fn, nil
 }
 
-func readZipFile(zf *zip.File) ([]byte, error) {
-	f, err := zf.Open()
-	if err != nil {
-		return nil, err
+// safeName returns base file name of zip entry, false for entries which
+// can't be extracted safely
+func safeName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	base := path.Base(path.Clean("/" + name))
+	if base == "/" || base == "." || base == ".." {
+		return "", false
 	}
-	defer f.Close()
-	return io.ReadAll(f)
+	return base, true
 }
 
 func ByteCountIEC(b int64) string {
@@ -107,26 +142,3 @@ func ByteCountIEC(b int64) string {
 	return fmt.Sprintf("%.1f %ciB",
 		float64(b)/float64(div), "KMGTPE"[exp])
 }
-
-func SaveDumpDate(d int) error {
-	f, err := os.Create("/tmp/lastrkndump")
-	if err != nil {
-		return err
-	}
-	fmt.Fprint(f, d)
-	f.Close()
-	return nil
-}
-
-func LoadDumpDate() (d int, err error) {
-	f, err := os.Open("/tmp/lastrkndump")
-	if err != nil {
-		return 0, err
-	}
-	b, err := io.ReadAll(f)
-	if err != nil {
-		return 0, err
-	}
-	d, err = strconv.Atoi(string(b))
-	return d, err
-}
@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeName(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"dump.xml", "dump.xml", true},
+		{"dir/dump.xml", "dump.xml", true},
+		{"../../etc/passwd", "passwd", true},
+		{"/etc/cron.d/x.xml", "x.xml", true},
+		{"..\\..\\windows\\dump.xml", "dump.xml", true},
+		{"a/../../dump.xml", "dump.xml", true},
+		{"..", "", false},
+		{"../", "", false},
+		{"/", "", false},
+		{"", "", false},
+		{".", "", false},
+		{"dir/..", "", false},
+	} {
+		got, ok := safeName(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("safeName(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFindXMLInZipTraversal(t *testing.T) {
+	dir := t.TempDir()
+	zfn := filepath.Join(dir, "dump.zip")
+	f, err := os.Create(zfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"../evil.xml", "../../dump.xml", "notes.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("<reg/>")) // nolint
+	}
+	if err = zw.Close(); err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdir := filepath.Join(dir, "state", "xml")
+	err = os.MkdirAll(xdir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, err := FindXMLInZipFile(zfn, xdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn != filepath.Join(xdir, "dump.xml") {
+		t.Errorf("extracted %s", fn)
+	}
+	var files []string
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error { // nolint
+		if err == nil && !fi.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	want := map[string]bool{"dump.zip": true, "state/xml/evil.xml": true, "state/xml/dump.xml": true}
+	if len(files) != len(want) {
+		t.Errorf("files %v", files)
+	}
+	for _, f := range files {
+		if !want[filepath.ToSlash(f)] {
+			t.Errorf("file %s written outside xml dir", f)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package downloader
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// error kinds of download cycle
+const (
+	// KindAuth wrong login or password
+	KindAuth = "auth"
+	// KindNotWhitelisted server IP is not added on the RKN portal, the
+	// service answers with html instead of soap
+	KindNotWhitelisted = "not_whitelisted"
+	// KindTransient network errors, timeouts and server errors
+	KindTransient = "transient"
+	// KindParse broken answer, archive or xml
+	KindParse = "parse"
+)
+
+// Error download error of known kind
+type Error struct {
+	Kind string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ParseError mark err as broken answer
+func ParseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: KindParse, Err: err}
+}
+
+// Classify returns kind of download error, errors without kind are
+// classified by text of soap and http errors
+func Classify(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	s := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(s, strings.ToLower(http.StatusText(http.StatusUnauthorized))),
+		strings.Contains(s, "авторизац"), strings.Contains(s, "парол"):
+		return KindAuth
+	case strings.Contains(s, strings.ToLower(http.StatusText(http.StatusForbidden))),
+		strings.Contains(s, "xml syntax error"):
+		return KindNotWhitelisted
+	case strings.Contains(s, "unmarshalling the body"):
+		return KindParse
+	}
+	return KindTransient
+}
+
+// persistent kinds are not fixed by fast retries
+func persistent(kind string) bool {
+	return kind == KindAuth || kind == KindNotWhitelisted
+}
+
+// circuit states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// Retry is retry policy with exponential backoff, jitter and circuit
+// breaker shared by download loops. Delay after n-th failure in a row is
+// Base*2^(n-1) limited by Max, with random ±Jitter part. After Threshold
+// failures in a row or at once on auth and whitelist errors circuit is
+// open: Allow rejects tries until RetryAt, then lets a single probe
+// through (half-open) and rejects others until it ends. Now and Rand can
+// be replaced for tests, other fields are changed with Configure
+type Retry struct {
+	Base      time.Duration
+	Max       time.Duration
+	Jitter    float64
+	Threshold int
+	Now       func() time.Time
+	Rand      func() float64
+
+	mu    sync.Mutex
+	state RetryState
+}
+
+// RetryState state of Retry for status api
+type RetryState struct {
+	Circuit   string    `json:"circuit"`
+	Failures  int       `json:"failures"`
+	LastKind  string    `json:"last_kind,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	LastFail  time.Time `json:"last_failure,omitempty"`
+	OpenedAt  time.Time `json:"opened_at,omitempty"`
+	RetryAt   time.Time `json:"retry_at,omitempty"`
+}
+
+func (r *Retry) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// NewRetry create retry policy with 20% jitter
+func NewRetry(base, max time.Duration, threshold int) *Retry {
+	return &Retry{Base: base, Max: max, Jitter: 0.2, Threshold: threshold}
+}
+
+// Configure change backoff and threshold of running policy
+func (r *Retry) Configure(base, max time.Duration, threshold int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Base, r.Max, r.Threshold = base, max, threshold
+}
+
+// delay returns backoff before try after n failures in a row
+func (r *Retry) delay(n int) time.Duration {
+	if n < 1 {
+		return 0
+	}
+	d := float64(r.Base) * math.Pow(2, float64(n-1))
+	if r.Max > 0 && d > float64(r.Max) {
+		d = float64(r.Max)
+	}
+	if r.Jitter > 0 {
+		rnd := rand.Float64
+		if r.Rand != nil {
+			rnd = r.Rand
+		}
+		d += d * r.Jitter * (2*rnd() - 1)
+	}
+	return time.Duration(d)
+}
+
+// Failure record failed try, returns error kind and wait before next try
+func (r *Retry) Failure(err error) (kind string, wait time.Duration) {
+	kind = Classify(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	st := &r.state
+	st.Failures++
+	st.LastKind = kind
+	st.LastError = err.Error()
+	st.LastFail = now
+	wait = r.delay(st.Failures)
+	if persistent(kind) {
+		// credentials and whitelist are fixed by people, not by retries,
+		// so wait 64 times longer
+		wait = r.delay(st.Failures + 6)
+	}
+	if st.Circuit == CircuitHalfOpen || st.Failures >= r.Threshold || persistent(kind) {
+		if st.Circuit != CircuitOpen && st.Circuit != CircuitHalfOpen {
+			st.OpenedAt = now
+		}
+		st.Circuit = CircuitOpen
+	}
+	st.RetryAt = now.Add(wait)
+	return kind, wait
+}
+
+// Success record successful try and close circuit
+func (r *Retry) Success() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = RetryState{Circuit: CircuitClosed}
+}
+
+// Allow check whether a try may start now. Closed circuit allows every
+// try, open one none until RetryAt and then one probe, circuit becomes
+// half-open until Success or Failure of the probe. Rejected try gets
+// wait before next check
+func (r *Retry) Allow() (ok bool, wait time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := &r.state
+	switch st.Circuit {
+	case CircuitOpen:
+		now := r.now()
+		if now.Before(st.RetryAt) {
+			return false, st.RetryAt.Sub(now)
+		}
+		st.Circuit = CircuitHalfOpen
+		return true, 0
+	case CircuitHalfOpen:
+		// probe is running
+		if r.Base > 0 {
+			return false, r.Base
+		}
+		return false, time.Second
+	}
+	return true, 0
+}
+
+// State returns current retry state
+func (r *Retry) State() RetryState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.state
+	if st.Circuit == "" {
+		st.Circuit = CircuitClosed
+	}
+	return st
+}
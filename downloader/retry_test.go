@@ -0,0 +1,225 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeClock time source of Retry moved by tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func newTestRetry(base, max time.Duration, threshold int) (*Retry, *fakeClock) {
+	c := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r := NewRetry(base, max, threshold)
+	r.Now = c.Now
+	r.Rand = func() float64 { return 0.5 }
+	return r, c
+}
+
+var errTimeout = &net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}
+
+func TestRetryBackoff(t *testing.T) {
+	r, _ := newTestRetry(30*time.Second, 5*time.Minute, 100)
+	want := []time.Duration{
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		5 * time.Minute,
+		5 * time.Minute,
+	}
+	for i, w := range want {
+		kind, wait := r.Failure(errTimeout)
+		if kind != KindTransient {
+			t.Fatalf("kind %s", kind)
+		}
+		if wait != w {
+			t.Errorf("failure %d: wait %s, want %s", i+1, wait, w)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		r.Failure(errTimeout)
+	}
+	if _, wait := r.Failure(errTimeout); wait != 5*time.Minute {
+		t.Errorf("wait after many failures %s, want max", wait)
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	r, _ := newTestRetry(100*time.Second, time.Hour, 100)
+	for _, tt := range []struct {
+		rnd  float64
+		want time.Duration
+	}{
+		{0, 80 * time.Second},
+		{0.25, 90 * time.Second},
+		{0.5, 100 * time.Second},
+		{0.75, 110 * time.Second},
+		{0.999999, 120 * time.Second},
+	} {
+		rnd := tt.rnd
+		r.Rand = func() float64 { return rnd }
+		d := r.delay(1)
+		if diff := d - tt.want; diff < -time.Millisecond || diff > time.Millisecond {
+			t.Errorf("rand %v: delay %s, want %s", tt.rnd, d, tt.want)
+		}
+		if d < 80*time.Second || d > 120*time.Second {
+			t.Errorf("rand %v: delay %s out of ±20%%", tt.rnd, d)
+		}
+	}
+	// capped delay gets jitter around Max
+	r.Rand = func() float64 { return 0 }
+	if d := r.delay(20); d != 48*time.Minute {
+		t.Errorf("capped delay %s, want 48m", d)
+	}
+	r.Jitter = 0
+	if d := r.delay(20); d != time.Hour {
+		t.Errorf("capped delay without jitter %s, want 1h", d)
+	}
+}
+
+func TestRetryCircuit(t *testing.T) {
+	r, c := newTestRetry(time.Second, time.Minute, 3)
+	start := c.t
+	if st := r.State(); st.Circuit != CircuitClosed || st.Failures != 0 {
+		t.Fatalf("initial state %+v", st)
+	}
+	r.Failure(errTimeout)
+	c.t = c.t.Add(time.Second)
+	r.Failure(errTimeout)
+	if st := r.State(); st.Circuit != CircuitClosed || st.Failures != 2 {
+		t.Fatalf("state before threshold %+v", st)
+	}
+	c.t = c.t.Add(2 * time.Second)
+	_, wait := r.Failure(errTimeout)
+	st := r.State()
+	if st.Circuit != CircuitOpen {
+		t.Fatalf("circuit %s at threshold, want open", st.Circuit)
+	}
+	opened := start.Add(3 * time.Second)
+	if !st.OpenedAt.Equal(opened) || !st.RetryAt.Equal(opened.Add(wait)) {
+		t.Errorf("opened %s retry %s", st.OpenedAt, st.RetryAt)
+	}
+	if st.LastKind != KindTransient || st.LastError != errTimeout.Error() || !st.LastFail.Equal(opened) {
+		t.Errorf("last failure %+v", st)
+	}
+
+	// open circuit rejects tries until retry time
+	c.t = opened.Add(wait / 2)
+	if ok, left := r.Allow(); ok || left != wait-wait/2 {
+		t.Fatalf("open circuit allowed try %v, wait %s", ok, left)
+	}
+	if st := r.State(); st.Circuit != CircuitOpen {
+		t.Fatalf("circuit %s after rejected try, want open", st.Circuit)
+	}
+	c.t = opened.Add(wait)
+	if ok, _ := r.Allow(); !ok {
+		t.Fatal("probe rejected after retry time")
+	}
+	if st := r.State(); st.Circuit != CircuitHalfOpen {
+		t.Fatalf("circuit %s after probe, want half-open", st.Circuit)
+	}
+	// single probe
+	if ok, _ := r.Allow(); ok {
+		t.Fatal("second try allowed while probe is running")
+	}
+	r.Failure(errTimeout)
+	st = r.State()
+	if st.Circuit != CircuitOpen || st.Failures != 4 {
+		t.Fatalf("state after failed probe %+v", st)
+	}
+	if !st.OpenedAt.Equal(opened) {
+		t.Errorf("failed probe moved opened_at to %s", st.OpenedAt)
+	}
+	if ok, _ := r.Allow(); ok {
+		t.Fatal("try allowed right after failed probe")
+	}
+
+	c.t = st.RetryAt
+	if ok, _ := r.Allow(); !ok {
+		t.Fatal("second probe rejected")
+	}
+	r.Success()
+	if st := r.State(); st.Circuit != CircuitClosed || st.Failures != 0 || st.LastKind != "" || !st.RetryAt.IsZero() {
+		t.Fatalf("state after success %+v", st)
+	}
+	// closed circuit allows every try
+	for i := 0; i < 3; i++ {
+		if ok, _ := r.Allow(); !ok {
+			t.Fatal("closed circuit rejected try")
+		}
+	}
+	if st := r.State(); st.Circuit != CircuitClosed {
+		t.Errorf("circuit %s after tries of closed", st.Circuit)
+	}
+	r.Failure(errTimeout)
+	if st := r.State(); st.Circuit != CircuitClosed || st.Failures != 1 {
+		t.Errorf("failures counted again from zero %+v", st)
+	}
+}
+
+func TestRetryPersistent(t *testing.T) {
+	for _, err := range []error{
+		&Error{Kind: KindAuth, Err: errors.New("no archive in answer: Неверный пароль")},
+		&Error{Kind: KindNotWhitelisted, Err: errors.New("html answer with status 200 OK")},
+	} {
+		r, c := newTestRetry(time.Second, 24*time.Hour, 5)
+		_, transient := r.Failure(errTimeout)
+		r.Success()
+		kind, wait := r.Failure(err)
+		if kind != err.(*Error).Kind {
+			t.Errorf("kind %s, want %s", kind, err.(*Error).Kind)
+		}
+		st := r.State()
+		if st.Circuit != CircuitOpen || !st.OpenedAt.Equal(c.t) {
+			t.Errorf("%s: circuit %s opened %s, want open at once", kind, st.Circuit, st.OpenedAt)
+		}
+		if wait != 64*transient {
+			t.Errorf("%s: wait %s, want %s", kind, wait, 64*transient)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		kind string
+	}{
+		// soap answers
+		{errors.New("no archive in answer: Ошибка авторизации"), KindAuth},
+		{errors.New("no archive in answer: Неверный логин или пароль"), KindAuth},
+		{errors.New("no archive in answer: запрос обрабатывается"), KindTransient},
+		{errors.New("soap fault: Internal Error"), KindTransient},
+		{errors.New("error unmarshalling the body: EOF"), KindParse},
+		// http statuses
+		{errors.New("unexpected status code: 401 Unauthorized"), KindAuth},
+		{errors.New("unexpected status code: 403 Forbidden"), KindNotWhitelisted},
+		{errors.New("unexpected status code: 503 Service Unavailable"), KindTransient},
+		// html page instead of soap
+		{errors.New("XML syntax error on line 5: element <br> closed by </body>"), KindNotWhitelisted},
+		{&Error{Kind: KindNotWhitelisted, Err: errors.New("html answer with status 200 OK")}, KindNotWhitelisted},
+		// network and marked errors
+		{errTimeout, KindTransient},
+		{io.ErrUnexpectedEOF, KindTransient},
+		{fmt.Errorf("unmarshal: %w", ParseError(errors.New("bad zip"))), KindParse},
+		{fmt.Errorf("download: %w", &Error{Kind: KindAuth, Err: errors.New("x")}), KindAuth},
+	} {
+		if k := Classify(tt.err); k != tt.kind {
+			t.Errorf("%q: kind %s, want %s", tt.err, k, tt.kind)
+		}
+	}
+	if ParseError(nil) != nil {
+		t.Error("ParseError(nil) is not nil")
+	}
+}
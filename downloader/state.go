@@ -0,0 +1,245 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prgra/rkndaemon/atomicfile"
+)
+
+// LegacyDumpDateFile used by older versions to keep last dump date
+const LegacyDumpDateFile = "/tmp/lastrkndump"
+
+// State directory layout
+//
+//	<dir>/lastdump   last applied dump date
+//	<dir>/dumpsynced  touched when applied dump is confirmed as latest
+//	<dir>/dump.zip   last downloaded archives, also social.zip
+//	<dir>/xml/       extracted xml files
+//	<dir>/archive/   downloaded zip archives
+//	<dir>/resolver_cache.json  accumulated dns answers
+//	<dir>/social.json  hash and times of last social register
+//	<dir>/pending.json  codes of sent requests waiting for result
+type State struct {
+	Dir string
+}
+
+// NewState create state directory layout
+func NewState(dir string) (*State, error) {
+	s := &State{Dir: filepath.Clean(dir)}
+	for _, d := range []string{s.Dir, s.XMLDir(), s.ArchiveDir()} {
+		err := os.MkdirAll(d, 0750)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// DumpDateFile path of last dump date file
+func (s *State) DumpDateFile() string {
+	return filepath.Join(s.Dir, "lastdump")
+}
+
+// DumpSyncedFile path of file touched when applied dump is confirmed as
+// latest by registry
+func (s *State) DumpSyncedFile() string {
+	return filepath.Join(s.Dir, "dumpsynced")
+}
+
+// TouchDumpSynced remember t as last time applied dump was latest
+func (s *State) TouchDumpSynced(t time.Time) error {
+	fn := s.DumpSyncedFile()
+	err := os.Chtimes(fn, t, t)
+	if !os.IsNotExist(err) {
+		return err
+	}
+	err = os.WriteFile(fn, nil, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(fn, t, t)
+}
+
+// DumpSynced returns last time applied dump was known to be latest: time
+// of last confirmation or of applying, zero time without applied dump
+func (s *State) DumpSynced() time.Time {
+	fi, err := os.Stat(s.DumpDateFile())
+	if err != nil {
+		return time.Time{}
+	}
+	t := fi.ModTime()
+	if fi, err = os.Stat(s.DumpSyncedFile()); err == nil && fi.ModTime().After(t) {
+		t = fi.ModTime()
+	}
+	return t
+}
+
+// ZipFile path of last downloaded zip of kind (dump, social)
+func (s *State) ZipFile(kind string) string {
+	return filepath.Join(s.Dir, kind+".zip")
+}
+
+// XMLDir directory for extracted xml
+func (s *State) XMLDir() string {
+	return filepath.Join(s.Dir, "xml")
+}
+
+// ArchiveDir directory for zip archives
+func (s *State) ArchiveDir() string {
+	return filepath.Join(s.Dir, "archive")
+}
+
+// ResolverCacheFile path of resolver cache
+func (s *State) ResolverCacheFile() string {
+	return filepath.Join(s.Dir, "resolver_cache.json")
+}
+
+// SocialFile path of social register state
+func (s *State) SocialFile() string {
+	return filepath.Join(s.Dir, "social.json")
+}
+
+// SocialState last social register, Checked is time of last download,
+// Changed of last download with different archive
+type SocialState struct {
+	Hash    string    `json:"hash"`
+	Checked time.Time `json:"checked"`
+	Changed time.Time `json:"changed"`
+}
+
+// SaveSocial save social register state
+func (s *State) SaveSocial(ss SocialState) error {
+	b, err := json.Marshal(ss)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.SocialFile(), b)
+}
+
+// LoadSocial load social register state, missing file gives empty state
+func (s *State) LoadSocial() (ss SocialState, err error) {
+	b, err := os.ReadFile(s.SocialFile())
+	if os.IsNotExist(err) {
+		return ss, nil
+	}
+	if err != nil {
+		return ss, err
+	}
+	err = json.Unmarshal(b, &ss)
+	return ss, err
+}
+
+// PendingFile path of codes of sent requests
+func (s *State) PendingFile() string {
+	return filepath.Join(s.Dir, "pending.json")
+}
+
+// PendingRequest request sent to registry whose result is not received
+// yet, it is asked again with the same code after restart
+type PendingRequest struct {
+	Code string    `json:"code"`
+	Sent time.Time `json:"sent"`
+}
+
+// LoadPending load pending requests by kind (dump, social), missing file
+// gives no requests
+func (s *State) LoadPending() (map[string]PendingRequest, error) {
+	res := make(map[string]PendingRequest)
+	b, err := os.ReadFile(s.PendingFile())
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(b, &res)
+	return res, err
+}
+
+// Pending returns pending request of kind, ok is false if there is none
+func (s *State) Pending(kind string) (r PendingRequest, ok bool, err error) {
+	l, err := s.LoadPending()
+	r, ok = l[kind]
+	return r, ok, err
+}
+
+// SavePending remember code of sent request of kind
+func (s *State) SavePending(kind string, r PendingRequest) error {
+	l, err := s.LoadPending()
+	if err != nil {
+		return err
+	}
+	l[kind] = r
+	return s.savePending(l)
+}
+
+// ClearPending forget request of kind once its result is received
+func (s *State) ClearPending(kind string) error {
+	l, err := s.LoadPending()
+	if err != nil {
+		return err
+	}
+	if _, ok := l[kind]; !ok {
+		return nil
+	}
+	delete(l, kind)
+	return s.savePending(l)
+}
+
+func (s *State) savePending(l map[string]PendingRequest) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.PendingFile(), b)
+}
+
+// SaveDumpDate save last applied dump date
+func (s *State) SaveDumpDate(d int) error {
+	return atomicfile.WriteFile(s.DumpDateFile(), []byte(strconv.Itoa(d)))
+}
+
+// LoadDumpDate load last applied dump date
+func (s *State) LoadDumpDate() (d int, err error) {
+	b, err := os.ReadFile(s.DumpDateFile())
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// MigrateLegacy move dump date from LegacyDumpDateFile if state has none
+func (s *State) MigrateLegacy() error {
+	_, err := os.Stat(s.DumpDateFile())
+	if err == nil {
+		return nil
+	}
+	b, err := os.ReadFile(LegacyDumpDateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("bad legacy dump date: %w", err)
+	}
+	err = s.SaveDumpDate(d)
+	if err != nil {
+		return err
+	}
+	log.Println("migrated dump date from", LegacyDumpDateFile, "to", s.DumpDateFile())
+	err = os.Remove(LegacyDumpDateFile)
+	if err != nil {
+		log.Println("can't remove", LegacyDumpDateFile, err)
+	}
+	return nil
+}
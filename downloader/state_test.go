@@ -0,0 +1,44 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPending(t *testing.T) {
+	s, err := NewState(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Pending("dump"); ok || err != nil {
+		t.Fatalf("pending request in empty state, err %v", err)
+	}
+	sent := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, kind := range []string{"dump", "social"} {
+		err = s.SavePending(kind, PendingRequest{Code: kind + "-code", Sent: sent})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// codes survive restart
+	s = &State{Dir: s.Dir}
+	r, ok, err := s.Pending("dump")
+	if err != nil || !ok || r.Code != "dump-code" || !r.Sent.Equal(sent) {
+		t.Fatalf("pending dump %+v %v %v", r, ok, err)
+	}
+	err = s.ClearPending("dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = s.Pending("dump"); ok {
+		t.Error("dump request kept after clear")
+	}
+	if r, ok, _ = s.Pending("social"); !ok || r.Code != "social-code" {
+		t.Errorf("social request lost with dump clear: %+v", r)
+	}
+	err = s.ClearPending("dump")
+	if err != nil {
+		t.Errorf("clear of missing request: %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prgra/rkndaemon/atomicfile"
+)
+
+// ZipElement element of soap answer with base64 zip archive
+const ZipElement = "registerZipArchive"
+
+// maxFieldLen limit of collected text of small answer elements
+const maxFieldLen = 4096
+
+// DownloadZip call soap method m and stream base64 archive of answer
+// into file fn, answer is never kept in memory. Returns sha256 and size
+// of archive
+func (d *Downloader) DownloadZip(ctx context.Context, m string, fn string) (hash string, size int64, err error) {
+	req, err := d.request(ctx, m)
+	if err != nil {
+		return "", 0, err
+	}
+	resp, err := d.SOAP.HTTPClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return "", 0, &Error{Kind: KindAuth, Err: errors.New("unexpected status code: " + resp.Status)}
+	case resp.StatusCode == http.StatusForbidden:
+		return "", 0, &Error{Kind: KindNotWhitelisted, Err: errors.New("unexpected status code: " + resp.Status)}
+	case strings.Contains(resp.Header.Get("Content-Type"), "html"):
+		return "", 0, &Error{Kind: KindNotWhitelisted, Err: fmt.Errorf("html answer with status %s", resp.Status)}
+	}
+	// soap faults come with status 500, so status is checked after body
+	h := sha256.New()
+	err = atomicfile.Write(fn, func(w io.Writer) error {
+		size, err = StreamZip(resp.Body, io.MultiWriter(w, h))
+		return err
+	})
+	if err != nil {
+		if resp.StatusCode >= 300 {
+			return "", 0, fmt.Errorf("unexpected status code: %s: %w", resp.Status, err)
+		}
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// request build soap request of method m without parameters
+func (d *Downloader) request(ctx context.Context, m string) (*http.Request, error) {
+	err := d.definitions()
+	if err != nil {
+		return nil, err
+	}
+	def := d.SOAP.Definitions
+	if len(def.Services) == 0 || len(def.Services[0].Ports) == 0 || len(def.Services[0].Ports[0].SoapAddresses) == 0 {
+		return nil, errors.New("no soap address in wsdl")
+	}
+	ns := ""
+	if len(def.Types) > 0 && len(def.Types[0].XsdSchema) > 0 {
+		ns = def.Types[0].XsdSchema[0].TargetNamespace
+	}
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><`)
+	body.WriteString(m + ` xmlns="`)
+	xmlEscape(&body, ns)
+	body.WriteString(`"></` + m + `></soap:Body></soap:Envelope>`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, def.Services[0].Ports[0].SoapAddresses[0].Location, &body)
+	if err != nil {
+		return nil, err
+	}
+	if d.SOAP.Username != "" && d.SOAP.Password != "" {
+		req.SetBasicAuth(d.SOAP.Username, d.SOAP.Password)
+	}
+	req.Header.Set("Content-Type", "text/xml;charset=UTF-8")
+	req.Header.Set("Accept", "text/xml")
+	req.Header.Set("SOAPAction", def.GetSoapActionFromWsdlOperation(m))
+	return req, nil
+}
+
+func xmlEscape(b *bytes.Buffer, s string) {
+	r := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+	b.WriteString(r.Replace(s))
+}
+
+// definitions load wsdl if no soap call was made yet
+func (d *Downloader) definitions() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.SOAP.Definitions == nil {
+		d.SOAP.SetWSDL(d.wsdl)
+	}
+	if d.SOAP.Definitions == nil {
+		return errors.New("wsdl definitions not found")
+	}
+	return nil
+}
+
+// StreamZip find base64 archive in soap answer r and write decoded
+// archive to w, returns archive size
+func StreamZip(r io.Reader, w io.Writer) (int64, error) {
+	s := &elementScanner{r: bufio.NewReaderSize(r, 64<<10), fields: make(map[string]string)}
+	text, err := s.find(ZipElement, "result", "resultComment", "faultstring")
+	if err != nil {
+		return 0, err
+	}
+	if text == nil {
+		if f := s.fields["faultstring"]; f != "" {
+			return 0, fmt.Errorf("soap fault: %s", f)
+		}
+		resp := Resp{Result: s.fields["result"] == "true", ResultComment: s.fields["resultComment"]}
+		if err := resp.Err(); err != nil {
+			return 0, err
+		}
+		return 0, ParseError(fmt.Errorf("no %s in answer", ZipElement))
+	}
+	n, err := io.Copy(w, base64.NewDecoder(base64.StdEncoding, text))
+	var ce base64.CorruptInputError
+	if errors.As(err, &ce) {
+		return n, ParseError(fmt.Errorf("base64: %w", err))
+	}
+	if err != nil {
+		return n, err
+	}
+	if n == 0 {
+		return 0, ParseError(errors.New("empty archive"))
+	}
+	return n, nil
+}
+
+// elementScanner finds element in xml stream without building tokens,
+// so text of huge element can be read as stream
+type elementScanner struct {
+	r      *bufio.Reader
+	fields map[string]string
+}
+
+// find skip stream up to start of element with local name el and returns
+// reader of its text, nil if there is no such element. Text of small
+// elements with names from fields is collected
+func (s *elementScanner) find(el string, fields ...string) (io.Reader, error) {
+	collect := ""
+	for {
+		text, err := s.r.ReadSlice('<')
+		if collect != "" {
+			t := s.fields[collect] + strings.TrimSuffix(string(text), "<")
+			if len(t) <= maxFieldLen {
+				s.fields[collect] = t
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		tag, err := s.r.ReadSlice('>')
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		collect = ""
+		if len(tag) == 0 || tag[0] == '/' || tag[0] == '?' || tag[0] == '!' {
+			continue
+		}
+		name := strings.TrimSuffix(string(tag), ">")
+		selfClosing := strings.HasSuffix(name, "/")
+		if i := strings.IndexAny(name, " \t\r\n/"); i >= 0 {
+			name = name[:i]
+		}
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			name = name[i+1:]
+		}
+		if selfClosing {
+			continue
+		}
+		if name == el {
+			return &textReader{r: s.r}, nil
+		}
+		for _, f := range fields {
+			if name == f {
+				collect = f
+			}
+		}
+	}
+}
+
+// textReader reads element text up to next tag, whitespace is dropped
+type textReader struct {
+	r    *bufio.Reader
+	done bool
+}
+
+func (t *textReader) Read(p []byte) (int, error) {
+	if t.done {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		c, err := t.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		if c == '<' {
+			t.done = true
+			break
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		p[n] = c
+		n++
+	}
+	if n == 0 && t.done {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// OpenXMLInZip open last xml file of zip fn for reading, returns its
+// name, file is not extracted
+func OpenXMLInZip(fn string) (io.ReadCloser, string, error) {
+	zr, err := zip.OpenReader(fn)
+	if err != nil {
+		return nil, "", err
+	}
+	var xf *zip.File
+	for _, f := range zr.File {
+		if name, ok := safeName(f.Name); ok && strings.HasSuffix(name, ".xml") {
+			xf = f
+		}
+	}
+	if xf == nil {
+		zr.Close()
+		return nil, "", fmt.Errorf("no xml file in zip")
+	}
+	rc, err := xf.Open()
+	if err != nil {
+		zr.Close()
+		return nil, "", err
+	}
+	return &zipEntry{ReadCloser: rc, zr: zr}, xf.Name, nil
+}
+
+type zipEntry struct {
+	io.ReadCloser
+	zr *zip.ReadCloser
+}
+
+func (z *zipEntry) Close() error {
+	z.ReadCloser.Close()
+	return z.zr.Close()
+}
+
+// FindXMLInZipFile extract xml files from zip file fn into dir, returns
+// path of last extracted file
+func FindXMLInZipFile(fn string, dir string) (string, error) {
+	zr, err := zip.OpenReader(fn)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	return extractXML(&zr.Reader, dir)
+}
+
+// copyFile copy src to dst through temporary file
+func copyFile(dst, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return atomicfile.Write(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
@@ -3,10 +3,10 @@ module github.com/prgra/rkndaemon
 go 1.17
 
 require (
-	github.com/bogdanovich/dns_resolver v0.0.0-20170211073258-a8e42bc6a5b6
 	github.com/cristalhq/aconfig v0.18.5
 	github.com/cristalhq/aconfig/aconfigtoml v0.17.1
 	github.com/davecgh/go-spew v1.1.1
+	github.com/miekg/dns v1.1.59
 	github.com/tiaguinho/gosoap v1.4.4
 	golang.org/x/net v0.24.0
 	golang.org/x/text v0.14.0
@@ -14,7 +14,6 @@ require (
 
 require (
 	github.com/BurntSushi/toml v1.3.2 // indirect
-	github.com/miekg/dns v1.1.59 // indirect
 	golang.org/x/mod v0.17.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
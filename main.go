@@ -1,12 +1,37 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/prgra/rkndaemon/daemon"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "replay":
+			err = replayCmd(os.Args[2:])
+		case "parse":
+			err = parseCmd("parse", false, os.Args[2:])
+		case "parse-social":
+			err = parseCmd("parse-social", true, os.Args[2:])
+		case "resolve":
+			err = resolveCmd(os.Args[2:])
+		case "check":
+			err = checkCmd(os.Args[2:])
+		default:
+			log.Fatalf("unknown command %s", os.Args[1])
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 	var cfg daemon.Config
 	err := cfg.Load()
 	if err != nil {
@@ -16,6 +41,37 @@ func main() {
 	if err != nil {
 		log.Fatalf("cant't create daemon: %v", err)
 	}
-	app.Run()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(ctx, cancel, app)
+	app.Run(ctx)
+
+}
 
+// handleSignals SIGINT/SIGTERM stop daemon, SIGHUP reload config,
+// SIGUSR1 force refresh
+func handleSignals(ctx context.Context, cancel context.CancelFunc, app *daemon.App) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			log.Println("got signal", sig)
+			switch sig {
+			case syscall.SIGHUP:
+				err := app.ReloadConfig()
+				if err != nil {
+					log.Printf("can't reload config, keep old: %v", err)
+				}
+			case syscall.SIGUSR1:
+				app.Refresh()
+			default:
+				cancel()
+				return
+			}
+		}
+	}
 }
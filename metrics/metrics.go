@@ -0,0 +1,142 @@
+// Package metrics simple counters and gauges in prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry of metrics
+type Registry struct {
+	mu      sync.Mutex
+	help    map[string]string
+	types   map[string]string
+	metrics map[string]map[string]float64 // name -> labels -> value
+}
+
+// Default registry used by package functions
+var Default = NewRegistry()
+
+// NewRegistry create empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		help:    make(map[string]string),
+		types:   make(map[string]string),
+		metrics: make(map[string]map[string]float64),
+	}
+}
+
+// Describe set type (counter, gauge) and help of metric
+func (r *Registry) Describe(name string, typ string, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[name] = typ
+	r.help[name] = help
+}
+
+// Set gauge value, labels are key value pairs
+func (r *Registry) Set(name string, v float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values(name)[formatLabels(labels)] = v
+}
+
+// Add add v to counter, labels are key value pairs
+func (r *Registry) Add(name string, v float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values(name)[formatLabels(labels)] += v
+}
+
+// Get returns current value
+func (r *Registry) Get(name string, labels ...string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.metrics[name][formatLabels(labels)]
+}
+
+func (r *Registry) values(name string) map[string]float64 {
+	m, ok := r.metrics[name]
+	if !ok {
+		m = make(map[string]float64)
+		r.metrics[name] = m
+	}
+	return m
+}
+
+// WriteTo write all metrics in prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		if h, ok := r.help[name]; ok {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, h)
+		}
+		if t, ok := r.types[name]; ok {
+			fmt.Fprintf(&b, "# TYPE %s %s\n", name, t)
+		}
+		labels := make([]string, 0, len(r.metrics[name]))
+		for l := range r.metrics[name] {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		for _, l := range labels {
+			fmt.Fprintf(&b, "%s%s %g\n", name, l, r.metrics[name][l])
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serve metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w) // nolint
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], v)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Describe metric in Default registry
+func Describe(name string, typ string, help string) {
+	Default.Describe(name, typ, help)
+}
+
+// Set gauge in Default registry
+func Set(name string, v float64, labels ...string) {
+	Default.Set(name, v, labels...)
+}
+
+// Add to counter in Default registry
+func Add(name string, v float64, labels ...string) {
+	Default.Add(name, v, labels...)
+}
+
+// Get value from Default registry
+func Get(name string, labels ...string) float64 {
+	return Default.Get(name, labels...)
+}
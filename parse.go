@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/prgra/rkndaemon/daemon"
+)
+
+// parseCmd process local dump or social register without network
+func parseCmd(name string, social bool, args []string) error {
+	var cfg daemon.Config
+	err := cfg.LoadLocal()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	out := fs.String("o", cfg.OutputDir, "output directory")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rkndaemon %s [-o outdir] <file.xml|file.zip>...\n", name)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	app, err := daemon.NewOffline(cfg)
+	if err != nil {
+		return err
+	}
+	for _, fn := range fs.Args() {
+		err = app.ParseFile(context.Background(), social, fn, *out)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+	log.Println("outputs written to", *out)
+	return nil
+}
@@ -0,0 +1,182 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+)
+
+type ipRange struct {
+	start, end *big.Int
+	bits       int
+}
+
+// AggregateCIDRs merge overlapping and adjacent networks into minimal
+// list of cidrs, ipv4 first, bad entries are skipped
+func AggregateCIDRs(l []string) []string {
+	return append(rangesToCIDRs(mergeRanges(toRanges(l, 32))), rangesToCIDRs(mergeRanges(toRanges(l, 128)))...)
+}
+
+func mergeRanges(l []ipRange) []ipRange {
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].start.Cmp(l[j].start) < 0
+	})
+	var res []ipRange
+	one := big.NewInt(1)
+	for _, r := range l {
+		if len(res) > 0 {
+			last := &res[len(res)-1]
+			if new(big.Int).Add(last.end, one).Cmp(r.start) >= 0 {
+				if r.end.Cmp(last.end) > 0 {
+					last.end = r.end
+				}
+				continue
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// rangesToCIDRs split ranges into largest aligned networks
+func rangesToCIDRs(l []ipRange) []string {
+	var res []string
+	one := big.NewInt(1)
+	for _, r := range l {
+		start := new(big.Int).Set(r.start)
+		for start.Cmp(r.end) <= 0 {
+			// largest block aligned at start and not beyond end
+			size := 0
+			for size < r.bits {
+				if start.Bit(size) != 0 {
+					break
+				}
+				blockEnd := new(big.Int).Lsh(one, uint(size+1))
+				blockEnd.Add(blockEnd, start)
+				blockEnd.Sub(blockEnd, one)
+				if blockEnd.Cmp(r.end) > 0 {
+					break
+				}
+				size++
+			}
+			b := start.Bytes()
+			ip := make(net.IP, r.bits/8)
+			copy(ip[len(ip)-len(b):], b)
+			n := net.IPNet{IP: ip, Mask: net.CIDRMask(r.bits-size, r.bits)}
+			res = append(res, n.String())
+			start.Add(start, new(big.Int).Lsh(one, uint(size)))
+		}
+	}
+	return res
+}
+
+// SubtractCIDRs returns networks of l without addresses of remove as
+// minimal list of cidrs
+func SubtractCIDRs(l []string, remove []string) []string {
+	var res []string
+	for _, fam := range []int{32, 128} {
+		nets := mergeRanges(toRanges(l, fam))
+		rm := mergeRanges(toRanges(remove, fam))
+		one := big.NewInt(1)
+		var left []ipRange
+		for _, n := range nets {
+			start := new(big.Int).Set(n.start)
+			for _, r := range rm {
+				if r.end.Cmp(start) < 0 || r.start.Cmp(n.end) > 0 {
+					continue
+				}
+				if r.start.Cmp(start) > 0 {
+					left = append(left, ipRange{start: start, end: new(big.Int).Sub(r.start, one), bits: fam})
+				}
+				start = new(big.Int).Add(r.end, one)
+			}
+			if start.Cmp(n.end) <= 0 {
+				left = append(left, ipRange{start: start, end: n.end, bits: fam})
+			}
+		}
+		res = append(res, rangesToCIDRs(left)...)
+	}
+	return res
+}
+
+// ParseNetsStrict parse cidrs and single ips like ParseNets, blank
+// entries are skipped and bad entry is an error
+func ParseNetsStrict(l []string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, s := range l {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		n := parseNet(s)
+		if n == nil {
+			return nil, fmt.Errorf("bad ip or cidr %s", s)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// Contains reports whether ip is in one of nets
+func Contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNets parse cidrs and single ips, bad entries are skipped
+func ParseNets(l []string) []*net.IPNet {
+	var res []*net.IPNet
+	for _, s := range l {
+		n := parseNet(s)
+		if n != nil {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+func parseNet(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err == nil {
+		return n
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// toRanges convert networks of family (32 or 128 bits) to ranges
+func toRanges(l []string, family int) []ipRange {
+	var res []ipRange
+	for _, s := range l {
+		n := parseNet(s)
+		if n == nil {
+			continue
+		}
+		ones, bits := n.Mask.Size()
+		if bits != family {
+			continue
+		}
+		ip := n.IP.To16()
+		if bits == 32 {
+			ip = n.IP.To4()
+		}
+		start := new(big.Int).SetBytes(ip)
+		end := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+		end.Add(end, start)
+		end.Sub(end, big.NewInt(1))
+		res = append(res, ipRange{start: start, end: end, bits: bits})
+	}
+	return res
+}
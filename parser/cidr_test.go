@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregateCIDRs(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"empty", nil, nil},
+		{"single ip", []string{"10.0.0.1"}, []string{"10.0.0.1/32"}},
+		{"adjacent", []string{"10.0.0.0/25", "10.0.0.128/25"}, []string{"10.0.0.0/24"}},
+		{"adjacent ips", []string{"10.0.0.2", "10.0.0.3", "10.0.0.1", "10.0.0.0"}, []string{"10.0.0.0/30"}},
+		{"nested", []string{"10.0.0.0/8", "10.1.2.0/24", "10.255.255.255"}, []string{"10.0.0.0/8"}},
+		{"overlapping", []string{"10.0.0.0/23", "10.0.1.0/24", "10.0.2.0/24"}, []string{"10.0.0.0/23", "10.0.2.0/24"}},
+		{"unaligned range", []string{"10.0.0.1", "10.0.0.2/31", "10.0.0.4/30"}, []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/30"}},
+		{"gap kept", []string{"10.0.0.0/24", "10.0.2.0/24"}, []string{"10.0.0.0/24", "10.0.2.0/24"}},
+		{"not normalized", []string{"10.0.0.77/24"}, []string{"10.0.0.0/24"}},
+		{"all v4", []string{"0.0.0.0/1", "128.0.0.0/1"}, []string{"0.0.0.0/0"}},
+		{"end of space", []string{"255.255.255.254", "255.255.255.255"}, []string{"255.255.255.254/31"}},
+		{
+			"v4 and v6, v4 first",
+			[]string{"2a00:bdc0::/37", "10.0.0.0/24", "2a00:bdc0:800::/37", "::1", "10.0.1.0/24"},
+			[]string{"10.0.0.0/23", "::1/128", "2a00:bdc0::/36"},
+		},
+		{"v6 nested", []string{"2001:db8::/32", "2001:db8:1::/48", "2001:db8::1"}, []string{"2001:db8::/32"}},
+		{"invalid skipped", []string{"bad", "", "10.0.0.0/33", "300.1.1.1", "10.0.0.0/24", "2001:db8::/129"}, []string{"10.0.0.0/24"}},
+	} {
+		got := AggregateCIDRs(tt.in)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: AggregateCIDRs(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSubtractCIDRs(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		in     []string
+		remove []string
+		want   []string
+	}{
+		{"nothing to remove", []string{"10.0.0.0/24"}, nil, []string{"10.0.0.0/24"}},
+		{"disjoint", []string{"10.0.0.0/24"}, []string{"10.0.1.0/24", "192.168.0.1"}, []string{"10.0.0.0/24"}},
+		{
+			"middle ip", []string{"10.0.0.0/29"}, []string{"10.0.0.3"},
+			[]string{"10.0.0.0/31", "10.0.0.2/32", "10.0.0.4/30"},
+		},
+		{
+			"middle subnet", []string{"10.0.0.0/22"}, []string{"10.0.1.0/24"},
+			[]string{"10.0.0.0/24", "10.0.2.0/23"},
+		},
+		{"start edge", []string{"10.0.0.0/24"}, []string{"10.0.0.0/25"}, []string{"10.0.0.128/25"}},
+		{"end edge", []string{"10.0.0.0/24"}, []string{"10.0.0.255"}, []string{"10.0.0.0/25", "10.0.0.128/26", "10.0.0.192/27", "10.0.0.224/28", "10.0.0.240/29", "10.0.0.248/30", "10.0.0.252/31", "10.0.0.254/32"}},
+		{"whole prefix", []string{"10.0.0.0/24", "10.0.1.0/24"}, []string{"10.0.0.0/24"}, []string{"10.0.1.0/24"}},
+		{"covering remove", []string{"10.0.0.0/24"}, []string{"10.0.0.0/16"}, nil},
+		{"remove v4 /0", []string{"10.0.0.0/8", "1.2.3.4", "2001:db8::/32"}, []string{"0.0.0.0/0"}, []string{"2001:db8::/32"}},
+		{"remove v6 /0", []string{"10.0.0.0/8", "2001:db8::/32"}, []string{"::/0"}, []string{"10.0.0.0/8"}},
+		{
+			"overlapping removes", []string{"10.0.0.0/24"}, []string{"10.0.0.0/26", "10.0.0.32/27", "10.0.0.64/26"},
+			[]string{"10.0.0.128/25"},
+		},
+		{
+			"several networks", []string{"10.0.0.0/24", "10.0.2.0/24"}, []string{"10.0.0.128/25", "10.0.2.0/25"},
+			[]string{"10.0.0.0/25", "10.0.2.128/25"},
+		},
+		{
+			"v6 middle", []string{"2001:db8::/126"}, []string{"2001:db8::1"},
+			[]string{"2001:db8::/128", "2001:db8::2/127"},
+		},
+		{"families kept apart", []string{"10.0.0.0/24"}, []string{"::ffff:0:0/96", "::/1"}, []string{"10.0.0.0/24"}},
+		{"invalid skipped", []string{"bad", "10.0.0.0/30"}, []string{"nope", "10.0.0.0/31"}, []string{"10.0.0.2/31"}},
+	} {
+		got := SubtractCIDRs(tt.in, tt.remove)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: SubtractCIDRs(%q, %q) = %q, want %q", tt.name, tt.in, tt.remove, got, tt.want)
+		}
+	}
+}
+
+func TestParseNets(t *testing.T) {
+	nets := ParseNets([]string{"10.0.0.1", "10.0.0.0/8", "bad", "2001:db8::1", "2001:db8::/32", "10.0.0.0/40"})
+	var got []string
+	for _, n := range nets {
+		got = append(got, n.String())
+	}
+	want := []string{"10.0.0.1/32", "10.0.0.0/8", "2001:db8::1/128", "2001:db8::/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseNets = %q, want %q", got, want)
+	}
+}
+
+func TestParseNetsStrict(t *testing.T) {
+	nets, err := ParseNetsStrict([]string{" 10.0.0.1 ", "", "2001:db8::/32", "::ffff:10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, n := range nets {
+		got = append(got, n.String())
+	}
+	want := []string{"10.0.0.1/32", "2001:db8::/32", "10.0.0.2/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseNetsStrict = %q, want %q", got, want)
+	}
+	for _, bad := range []string{"bad", "10.0.0.0/33", "10.0.0.256"} {
+		_, err = ParseNetsStrict([]string{"10.0.0.1", bad})
+		if err == nil {
+			t.Errorf("ParseNetsStrict accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// ConflictsFile json with conflicts between blocking and social registers
+const ConflictsFile = "conflicts.json"
+
+// Conflict blocked entry which hits socially significant resource,
+// Kind is ip, subnet, domain or mask
+type Conflict struct {
+	Kind     string   `json:"kind"`
+	Blocked  string   `json:"blocked"`
+	Social   string   `json:"social"`
+	BlockIDs []int    `json:"block_ids,omitempty"`
+	SocIDs   []int    `json:"soc_ids"`
+	SocNames []string `json:"soc_names"`
+}
+
+type socNet struct {
+	s   string
+	net *net.IPNet
+}
+
+// Conflicts find blocked ips and subnets overlapping social subnets and
+// blocked domains and masks matching social domains
+func (db *DB) Conflicts() []Conflict {
+	var nets []socNet
+	for s := range db.SocNets {
+		if n := parseNet(s); n != nil {
+			nets = append(nets, socNet{s: s, net: n})
+		}
+	}
+	var res []Conflict
+	add := func(kind, blocked, social string) {
+		c := Conflict{Kind: kind, Blocked: blocked, Social: social, BlockIDs: db.Records[blocked]}
+		c.SocIDs, c.SocNames = db.socRecordsOf(social)
+		res = append(res, c)
+	}
+	for s := range db.BlockedIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			continue
+		}
+		for _, sn := range nets {
+			if sn.net.Contains(ip) {
+				add("ip", s, sn.s)
+			}
+		}
+	}
+	for s := range db.Subnets {
+		n := parseNet(s)
+		if n == nil {
+			continue
+		}
+		for _, sn := range nets {
+			if overlaps([]*net.IPNet{sn.net}, n) {
+				add("subnet", s, sn.s)
+			}
+		}
+	}
+	for d := range db.SocDomains {
+		d = strings.ToLower(d)
+		for b := range db.Domains {
+			if b == d || strings.HasSuffix(b, "."+d) {
+				add("domain", b, d)
+			}
+		}
+		for m := range db.DomainMasks {
+			base := strings.TrimPrefix(m, "*.")
+			if base == m {
+				continue
+			}
+			if d == base || strings.HasSuffix(d, "."+base) {
+				add("mask", m, d)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Kind != res[j].Kind {
+			return res[i].Kind < res[j].Kind
+		}
+		if res[i].Blocked != res[j].Blocked {
+			return res[i].Blocked < res[j].Blocked
+		}
+		return res[i].Social < res[j].Social
+	})
+	return res
+}
+
+// socRecordsOf returns ids and names of social records with subnet or domain s
+func (db *DB) socRecordsOf(s string) (ids []int, names []string) {
+	for _, r := range db.SocList() {
+		match := strings.EqualFold(r.Domain, s)
+		for _, n := range r.Subnets {
+			match = match || n == s
+		}
+		if match {
+			ids = append(ids, r.ID)
+			names = append(names, r.Name)
+		}
+	}
+	return ids, names
+}
+
+// WriteConflicts write Conflicts as json
+func WriteConflicts(fn string, l []Conflict) error {
+	if l == nil {
+		l = []Conflict{}
+	}
+	return writeJSON(fn, l)
+}
+
+// Without returns ips of list not contained in nets
+func (l List) Without(nets []*net.IPNet) List {
+	res := make(List, len(l))
+	for s := range l {
+		ip := net.ParseIP(s)
+		if ip != nil && Contains(nets, ip) {
+			continue
+		}
+		res.Add(s)
+	}
+	return res
+}
+
+func overlaps(nets []*net.IPNet, n *net.IPNet) bool {
+	for _, sn := range nets {
+		if sn.Contains(n.IP) || n.Contains(sn.IP) {
+			return true
+		}
+	}
+	return false
+}
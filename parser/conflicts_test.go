@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestConflicts(t *testing.T) {
+	db := socDB(
+		SocRecord{ID: 1, Name: "VK", Domain: "VK.com", Subnets: []string{"87.240.128.0/18", "2a00:bdc0::/36"}},
+		SocRecord{ID: 2, Name: "Mail", Domain: "mail.ru", Subnets: []string{"87.240.128.0/18", "bad"}},
+	)
+	for _, c := range []Content{
+		{ID: 10, BlockType: "ip", IP: []string{"87.240.129.1", "87.241.0.1", "2a00:bdc0::1"}},
+		{ID: 11, IPSubnet: []string{"87.240.0.0/16", "87.240.130.0/24", "87.0.0.0/16", "2a00::/16", "nonsense"}},
+		{ID: 12, BlockType: "domain", Domain: []string{"m.vk.com", "notvk.com", "mail.ru"}},
+		{ID: 13, BlockType: "domain-mask", Domain: []string{"*.vk.com", "*.ru", "*.m.vk.com"}},
+	} {
+		db.ParseEl(c)
+	}
+	got := db.Conflicts()
+	for i := range got {
+		sort.Strings(got[i].SocNames)
+	}
+	both := func(kind, blocked string, id int) Conflict {
+		return Conflict{
+			Kind: kind, Blocked: blocked, Social: "87.240.128.0/18", BlockIDs: []int{id},
+			SocIDs: []int{1, 2}, SocNames: []string{"Mail", "VK"},
+		}
+	}
+	// sorted by kind, blocked and social as strings
+	want := []Conflict{
+		{Kind: "domain", Blocked: "m.vk.com", Social: "vk.com", BlockIDs: []int{12}, SocIDs: []int{1}, SocNames: []string{"VK"}},
+		{Kind: "domain", Blocked: "mail.ru", Social: "mail.ru", BlockIDs: []int{12}, SocIDs: []int{2}, SocNames: []string{"Mail"}},
+		{Kind: "ip", Blocked: "2a00:bdc0::1", Social: "2a00:bdc0::/36", BlockIDs: []int{10}, SocIDs: []int{1}, SocNames: []string{"VK"}},
+		both("ip", "87.240.129.1", 10),
+		{Kind: "mask", Blocked: "*.ru", Social: "mail.ru", BlockIDs: []int{13}, SocIDs: []int{2}, SocNames: []string{"Mail"}},
+		{Kind: "mask", Blocked: "*.vk.com", Social: "vk.com", BlockIDs: []int{13}, SocIDs: []int{1}, SocNames: []string{"VK"}},
+		{Kind: "subnet", Blocked: "2a00::/16", Social: "2a00:bdc0::/36", BlockIDs: []int{11}, SocIDs: []int{1}, SocNames: []string{"VK"}},
+		both("subnet", "87.240.0.0/16", 11),
+		both("subnet", "87.240.130.0/24", 11),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Conflicts\n got %+v\nwant %+v", got, want)
+	}
+
+	if l := NewDB().Conflicts(); len(l) != 0 {
+		t.Errorf("conflicts without social register: %+v", l)
+	}
+}
+
+func TestListWithout(t *testing.T) {
+	l := List{"10.0.0.1": true, "10.0.1.1": true, "2001:db8::1": true, "2001:db9::1": true, "example.com": true}
+	got := l.Without(ParseNets([]string{"10.0.0.0/24", "2001:db8::/32"}))
+	want := List{"10.0.1.1": true, "2001:db9::1": true, "example.com": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Without = %v, want %v", got, want)
+	}
+	if got := l.Without(ParseNets([]string{"0.0.0.0/0", "::/0"})); !reflect.DeepEqual(got, List{"example.com": true}) {
+		t.Errorf("Without /0 = %v", got)
+	}
+}
@@ -0,0 +1,43 @@
+package parser
+
+import "sort"
+
+// DumpChangesFile json with DumpDiff of last applied dump
+const DumpChangesFile = "DumpChanges.json"
+
+// DumpEntries entries of blocking register lists
+type DumpEntries struct {
+	URLs    []string `json:"urls"`
+	Domains []string `json:"domains"`
+	Masks   []string `json:"masks"`
+	IPs     []string `json:"ips"`
+	Subnets []string `json:"subnets"`
+}
+
+// Len count of entries in all lists
+func (e DumpEntries) Len() int {
+	return len(e.URLs) + len(e.Domains) + len(e.Masks) + len(e.IPs) + len(e.Subnets)
+}
+
+// DumpDiff entries added and removed by dump against previous one
+type DumpDiff struct {
+	Added   DumpEntries `json:"added"`
+	Removed DumpEntries `json:"removed"`
+}
+
+// WriteFile write diff as json
+func (d DumpDiff) WriteFile(fn string) error {
+	return writeJSON(fn, d)
+}
+
+// missingIn returns sorted entries of l not in o
+func (l List) missingIn(o List) []string {
+	res := []string{}
+	for k := range l {
+		if !o[k] {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -1,12 +1,14 @@
 package parser
 
 import (
+	"bufio"
 	"encoding/xml"
 	"fmt"
 	"log"
 	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -21,6 +23,7 @@ type Content struct {
 	URL        []string `xml:"url"`
 	BlockType  string   `xml:"-"`
 	EntityType string   `xml:"-"`
+	ID         int      `xml:"-"`
 }
 
 type List map[string]bool
@@ -37,6 +40,11 @@ type DB struct {
 	Subnets     List
 	SocNets     List
 	SocDomains  List
+	SocRecords  map[int]SocRecord
+	// Records ids of registry records by blocked ip, subnet and domain
+	Records map[string][]int
+	// SubtractSoc remove social subnets from ip outputs
+	SubtractSoc bool
 }
 
 func NewDB() *DB {
@@ -52,13 +60,57 @@ func NewDB() *DB {
 		Subnets:     make(List),
 		SocNets:     make(List),
 		SocDomains:  make(List),
+		SocRecords:  make(map[int]SocRecord),
+		Records:     make(map[string][]int),
 	}
 }
 
+// addRecord remember registry record id of entry
+func (db *DB) addRecord(entry string, id int) {
+	ids := db.Records[entry]
+	for _, i := range ids {
+		if i == id {
+			return
+		}
+	}
+	db.Records[entry] = append(ids, id)
+}
+
+// SetDump replace blocking register lists with lists of n, social
+// register is kept, returns entries added and removed
+func (db *DB) SetDump(n *DB) DumpDiff {
+	var d DumpDiff
+	for _, l := range []struct {
+		old, cur       List
+		added, removed *[]string
+	}{
+		{db.URLs, n.URLs, &d.Added.URLs, &d.Removed.URLs},
+		{db.Domains, n.Domains, &d.Added.Domains, &d.Removed.Domains},
+		{db.DomainMasks, n.DomainMasks, &d.Added.Masks, &d.Removed.Masks},
+		{db.BlockedIPs, n.BlockedIPs, &d.Added.IPs, &d.Removed.IPs},
+		{db.Subnets, n.Subnets, &d.Added.Subnets, &d.Removed.Subnets},
+	} {
+		*l.added = l.cur.missingIn(l.old)
+		*l.removed = l.old.missingIn(l.cur)
+	}
+	db.WhiteIp = n.WhiteIp
+	db.WhiteDomain = n.WhiteDomain
+	db.AllIPs = n.AllIPs
+	db.HTTPSIPs = n.HTTPSIPs
+	db.BlockedIPs = n.BlockedIPs
+	db.URLs = n.URLs
+	db.DomainMasks = n.DomainMasks
+	db.Domains = n.Domains
+	db.Subnets = n.Subnets
+	db.Records = n.Records
+	return d
+}
+
 func (l List) Add(s string) {
 	l[s] = true
 }
 func (db *DB) ParseSoc(item SocRecord) {
+	db.SocRecords[item.ID] = item
 	if item.Domain != "" {
 		db.SocDomains.Add(item.Domain)
 	}
@@ -74,6 +126,7 @@ func (db *DB) ParseEl(item Content) {
 		for i := range item.Domain {
 			d, _ := idna.ToASCII(item.Domain[i])
 			db.Domains.Add(d)
+			db.addRecord(d, item.ID)
 			u, err := url.Parse("http://" + item.Domain[i])
 			if err != nil {
 				continue
@@ -90,12 +143,14 @@ func (db *DB) ParseEl(item Content) {
 			ip := net.ParseIP(item.IP[i])
 			if ip.IsGlobalUnicast() {
 				db.BlockedIPs.Add(ip.String())
+				db.addRecord(ip.String(), item.ID)
 			}
 		}
 	case "domain-mask":
 		for i := range item.Domain {
 			sd := item.Domain[i]
 			db.DomainMasks.Add(sd)
+			db.addRecord(sd, item.ID)
 			if strings.HasPrefix(sd, "*.") {
 				d, _ := idna.ToASCII(strings.TrimPrefix(sd, ".*"))
 				db.DomainMasks.Add(d)
@@ -143,6 +198,7 @@ func (db *DB) ParseEl(item Content) {
 			if item.BlockType == "domain-mask" ||
 				item.BlockType == "domain" {
 				db.BlockedIPs.Add(ip.String())
+				db.addRecord(ip.String(), item.ID)
 			}
 			db.URLs.Add("http://" + ip.String())
 		}
@@ -150,6 +206,7 @@ func (db *DB) ParseEl(item Content) {
 
 	for i := range item.IPSubnet {
 		db.Subnets.Add(item.IPSubnet[i])
+		db.addRecord(item.IPSubnet[i], item.ID)
 	}
 }
 
@@ -159,7 +216,7 @@ func (db *DB) WriteFiles(dir string) error {
 	f, err := os.Stat(dir)
 
 	if err != nil {
-		err2 := os.MkdirAll(dir, 755)
+		err2 := os.MkdirAll(dir, 0755)
 		if err2 != nil {
 			return err2
 		}
@@ -169,11 +226,34 @@ func (db *DB) WriteFiles(dir string) error {
 		return fmt.Errorf("file no dir")
 	}
 
-	err = db.AllIPs.WriteFile(fmt.Sprintf("%s/allips.txt", dir))
+	allIPs, blockedIPs, httpsIPs, subnets := db.AllIPs, db.BlockedIPs, db.HTTPSIPs, db.Subnets
+	if db.SubtractSoc && len(db.SocNets) > 0 {
+		var soc []string
+		for k := range db.SocNets {
+			soc = append(soc, k)
+		}
+		nets := ParseNets(soc)
+		allIPs = allIPs.Without(nets)
+		blockedIPs = blockedIPs.Without(nets)
+		httpsIPs = httpsIPs.Without(nets)
+		subnets = make(List, len(db.Subnets))
+		for k := range db.Subnets {
+			n := parseNet(k)
+			if n == nil || !overlaps(nets, n) {
+				subnets.Add(k)
+				continue
+			}
+			for _, p := range SubtractCIDRs([]string{k}, soc) {
+				subnets.Add(p)
+			}
+		}
+	}
+
+	err = allIPs.WriteFile(fmt.Sprintf("%s/allips.txt", dir))
 	if err != nil {
 		return err
 	}
-	err = db.BlockedIPs.WriteFile(fmt.Sprintf("%s/bloked_ips.txt", dir))
+	err = blockedIPs.WriteFile(fmt.Sprintf("%s/bloked_ips.txt", dir))
 	if err != nil {
 		return err
 	}
@@ -181,7 +261,7 @@ func (db *DB) WriteFiles(dir string) error {
 	if err != nil {
 		return err
 	}
-	err = db.Subnets.WriteFile(fmt.Sprintf("%s/subnets.txt", dir))
+	err = subnets.WriteFile(fmt.Sprintf("%s/subnets.txt", dir))
 	if err != nil {
 		return err
 	}
@@ -193,7 +273,7 @@ func (db *DB) WriteFiles(dir string) error {
 	if err != nil {
 		return err
 	}
-	err = db.HTTPSIPs.WriteFile(fmt.Sprintf("%s/https_ips.txt", dir))
+	err = httpsIPs.WriteFile(fmt.Sprintf("%s/https_ips.txt", dir))
 	if err != nil {
 		return err
 	}
@@ -205,13 +285,17 @@ func (db *DB) WriteFiles(dir string) error {
 	return nil
 }
 
-func (db *DB) WriteSocialFiles(dir string) error {
+// WriteSocialFiles write social outputs and diff to dir. Everything is
+// written to temporary directory in dir first and moved into place only
+// after all writes succeed, SocRecordsFile goes last as it is the base of
+// next diff. Failed write keeps previous files
+func (db *DB) WriteSocialFiles(dir string, diff SocDiff) error {
 	log.Println("start write social files")
 
 	f, err := os.Stat(dir)
 
 	if err != nil {
-		err2 := os.MkdirAll(dir, 755)
+		err2 := os.MkdirAll(dir, 0755)
 		if err2 != nil {
 			return err2
 		}
@@ -220,36 +304,53 @@ func (db *DB) WriteSocialFiles(dir string) error {
 	if err == nil && !f.IsDir() {
 		return fmt.Errorf("file no dir")
 	}
-	err = db.SocNets.WriteFile(fmt.Sprintf("%s/SocNets.txt", dir))
+	tmp, err := os.MkdirTemp(dir, ".social.*")
 	if err != nil {
 		return err
 	}
-	err = db.SocDomains.WriteFile(fmt.Sprintf("%s/SocDomains.txt", dir))
+	defer os.RemoveAll(tmp)
+	err = db.SocNets.WriteFile(fmt.Sprintf("%s/SocNets.txt", tmp))
 	if err != nil {
 		return err
 	}
-	return nil
+	err = db.SocDomains.WriteFile(fmt.Sprintf("%s/SocDomains.txt", tmp))
+	if err != nil {
+		return err
+	}
+	err = writeJSON(fmt.Sprintf("%s/%s", tmp, SocRecordsFile), db.SocList())
+	if err != nil {
+		return err
+	}
+	err = diff.WriteFile(fmt.Sprintf("%s/%s", tmp, SocChangesFile))
+	if err != nil {
+		return err
+	}
+	err = db.WriteSocialSets(tmp)
+	if err != nil {
+		return err
+	}
+	err = moveFiles(tmp, dir, SocRecordsFile)
+	if err != nil {
+		return err
+	}
+	return removeStaleSets(filepath.Join(dir, SocSetsDir), db.SocSets())
 }
 
 func (l List) WriteFilef(format string, fn string) error {
-	f, err := os.Create(fn)
-	if err != nil {
-		return nil
-	}
 	var arr []string
 	for k := range l {
 		arr = append(arr, k)
 	}
 	sort.Strings(arr)
-	rn := "\n"
-	for i := range arr {
-		if i == len(arr)-1 {
-			rn = ""
+	return writeWith(fn, func(w *bufio.Writer) {
+		rn := "\n"
+		for i := range arr {
+			if i == len(arr)-1 {
+				rn = ""
+			}
+			fmt.Fprintf(w, format+rn, arr[i])
 		}
-		fmt.Fprintf(f, format+rn, arr[i])
-	}
-	f.Close()
-	return nil
+	})
 }
 
 func (l List) WriteFile(fn string) error {
@@ -1,16 +1,139 @@
 package parser
 
 import (
+	"bufio"
+	"encoding/json"
 	"encoding/xml"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
 	"time"
 )
 
+// SocRecordsFile json with all records of social register
+const SocRecordsFile = "SocResources.json"
+
+// SocChangesFile json with SocDiff of last social register update
+const SocChangesFile = "SocChanges.json"
+
 type SocRecord struct {
-	XMLName     xml.Name  `xml:"content"`
-	ID          int       `xml:"-"`
-	IncludeTime time.Time `xml:"-"`
-	Hash        string    `xml:"-"`
-	Name        string    `xml:"resourceName"`
-	Domain      string    `xml:"domain"`
-	Subnets     []string  `xml:"ipSubnet"`
+	XMLName     xml.Name  `xml:"content" json:"-"`
+	ID          int       `xml:"-" json:"id"`
+	IncludeTime time.Time `xml:"-" json:"include_time"`
+	Hash        string    `xml:"-" json:"hash"`
+	Name        string    `xml:"resourceName" json:"name"`
+	Domain      string    `xml:"domain" json:"domain,omitempty"`
+	Subnets     []string  `xml:"ipSubnet" json:"subnets,omitempty"`
+}
+
+// SocDiff changes of social register between two fetches, records
+// are compared by ID and Hash
+type SocDiff struct {
+	Added   []SocRecord `json:"added"`
+	Removed []SocRecord `json:"removed"`
+	Changed []SocRecord `json:"changed"`
+}
+
+// DiffSoc compare old and current records
+func DiffSoc(old, cur map[int]SocRecord) SocDiff {
+	var d SocDiff
+	for id, r := range cur {
+		o, ok := old[id]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, r)
+		case o.Hash != r.Hash:
+			d.Changed = append(d.Changed, r)
+		}
+	}
+	for id, o := range old {
+		if _, ok := cur[id]; !ok {
+			d.Removed = append(d.Removed, o)
+		}
+	}
+	sortSoc(d.Added)
+	sortSoc(d.Removed)
+	sortSoc(d.Changed)
+	return d
+}
+
+// Empty diff has no changes
+func (d SocDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// WriteFile write diff as json
+func (d SocDiff) WriteFile(fn string) error {
+	return writeJSON(fn, d)
+}
+
+// LoadSocRecords load records written by WriteSocialFiles, missing file
+// gives no records
+func LoadSocRecords(fn string) (map[int]SocRecord, error) {
+	res := make(map[int]SocRecord)
+	b, err := os.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	var l []SocRecord
+	err = json.Unmarshal(b, &l)
+	for _, r := range l {
+		res[r.ID] = r
+	}
+	return res, err
+}
+
+// SocList returns records sorted by ID
+func (db *DB) SocList() []SocRecord {
+	l := make([]SocRecord, 0, len(db.SocRecords))
+	for _, r := range db.SocRecords {
+		l = append(l, r)
+	}
+	sortSoc(l)
+	return l
+}
+
+func sortSoc(l []SocRecord) {
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].ID < l[j].ID
+	})
+}
+
+func writeJSON(fn string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return err
+	}
+	return writeWith(fn, func(w *bufio.Writer) {
+		w.Write(b) // nolint
+	})
+}
+
+// moveFiles rename files of src tree into dst keeping relative paths,
+// last file of src root is moved after all others
+func moveFiles(src string, dst string, last string) error {
+	err := filepath.WalkDir(src, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, fn)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		if rel == last {
+			return nil
+		}
+		return os.Rename(fn, filepath.Join(dst, rel))
+	})
+	if err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(src, last), filepath.Join(dst, last))
 }
@@ -0,0 +1,305 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prgra/rkndaemon/atomicfile"
+)
+
+// SocSetsDir directory in output dir with per resource social sets
+const SocSetsDir = "social"
+
+// maxSetName ipset limit is 31, one char is left for ipv6 suffix
+const maxSetName = 30
+
+// SocSet allowlist of one social resource, records with the same
+// resourceName are grouped into one set
+type SocSet struct {
+	Set     string
+	Name    string
+	IDs     []int
+	Domains []string
+	// V4, V6 aggregated cidrs
+	V4 []string
+	V6 []string
+}
+
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// SetName transliterate resource name into identifier safe for ipset,
+// nftables and RouterOS: soc_ prefix, [a-z0-9_] only
+func SetName(name string) string {
+	var b strings.Builder
+	b.WriteString("soc_")
+	under := true
+	for _, r := range strings.ToLower(name) {
+		s, ok := translit[r]
+		switch {
+		case ok:
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			s = string(r)
+		default:
+			s = "_"
+		}
+		if s == "_" {
+			if under {
+				continue
+			}
+			under = true
+		} else if s != "" {
+			under = false
+		}
+		b.WriteString(s)
+	}
+	res := strings.TrimRight(b.String(), "_")
+	if len(res) > maxSetName {
+		res = strings.TrimRight(res[:maxSetName], "_")
+	}
+	return res
+}
+
+// SocSets returns social records grouped by resource name with unique
+// set names, sorted by resource name
+func (db *DB) SocSets() []SocSet {
+	byName := make(map[string]*SocSet)
+	var names []string
+	for _, r := range db.SocList() {
+		name := strings.TrimSpace(r.Name)
+		if name == "" {
+			name = strconv.Itoa(r.ID)
+		}
+		s, ok := byName[name]
+		if !ok {
+			s = &SocSet{Name: name}
+			byName[name] = s
+			names = append(names, name)
+		}
+		s.IDs = append(s.IDs, r.ID)
+		if r.Domain != "" {
+			s.Domains = append(s.Domains, r.Domain)
+		}
+		for _, n := range r.Subnets {
+			if strings.Contains(n, ":") {
+				s.V6 = append(s.V6, n)
+			} else {
+				s.V4 = append(s.V4, n)
+			}
+		}
+	}
+	sort.Strings(names)
+	used := make(map[string]bool)
+	res := make([]SocSet, 0, len(names))
+	for _, name := range names {
+		s := byName[name]
+		s.V4 = AggregateCIDRs(s.V4)
+		s.V6 = AggregateCIDRs(s.V6)
+		sort.Strings(s.Domains)
+		s.Set = uniqueSetName(SetName(name), s.IDs[0], used)
+		// ipv6 set of resource is written with 6 suffix
+		used[s.Set] = true
+		used[s.Set+"6"] = true
+		res = append(res, *s)
+	}
+	return res
+}
+
+// uniqueSetName returns base if neither it nor its ipv6 name is used,
+// otherwise base with record id and counter suffix
+func uniqueSetName(base string, id int, used map[string]bool) string {
+	name := base
+	for i := 0; used[name] || used[name+"6"]; i++ {
+		// different names with same transliteration
+		suffix := "_" + strconv.Itoa(id)
+		if i > 0 {
+			suffix += "_" + strconv.Itoa(i)
+		}
+		b := base
+		if len(b)+len(suffix) > maxSetName {
+			b = b[:maxSetName-len(suffix)]
+		}
+		name = b + suffix
+	}
+	return name
+}
+
+// WriteSocialSets write per resource allowlists to dir/social: set.txt
+// (cidrs) and set.domains.txt for every resource, sets.csv index and
+// social.ipset, social.nft, social.rsc for ipset restore, nft -f and
+// RouterOS import, also SocNetsAggregated.txt with all cidrs to dir.
+// Files of sets missing in current registry are removed
+func (db *DB) WriteSocialSets(dir string) error {
+	sets := db.SocSets()
+	sdir := filepath.Join(dir, SocSetsDir)
+	err := os.MkdirAll(sdir, 0755)
+	if err != nil {
+		return err
+	}
+	var all []string
+	for _, s := range sets {
+		all = append(all, s.V4...)
+		all = append(all, s.V6...)
+		err = writeLines(filepath.Join(sdir, s.Set+".txt"), append(s.V4, s.V6...))
+		if err != nil {
+			return err
+		}
+		err = writeLines(filepath.Join(sdir, s.Set+".domains.txt"), s.Domains)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeLines(filepath.Join(dir, "SocNetsAggregated.txt"), AggregateCIDRs(all))
+	if err != nil {
+		return err
+	}
+	for _, e := range []struct {
+		fn string
+		f  func(*bufio.Writer, []SocSet)
+	}{
+		{"sets.csv", writeSetsIndex},
+		{"social.ipset", writeIpset},
+		{"social.nft", writeNft},
+		{"social.rsc", writeRouterOS},
+	} {
+		err = writeWith(filepath.Join(sdir, e.fn), func(w *bufio.Writer) { e.f(w, sets) })
+		if err != nil {
+			return err
+		}
+	}
+	return removeStaleSets(sdir, sets)
+}
+
+// removeStaleSets remove set.txt and set.domains.txt of resources gone
+// from registry, other files in sdir are kept
+func removeStaleSets(sdir string, sets []SocSet) error {
+	keep := make(map[string]bool, len(sets))
+	for _, s := range sets {
+		keep[s.Set] = true
+	}
+	entries, err := os.ReadDir(sdir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, "soc_") || !strings.HasSuffix(name, ".txt") {
+			continue
+		}
+		set := strings.TrimSuffix(strings.TrimSuffix(name, ".txt"), ".domains")
+		if keep[set] {
+			continue
+		}
+		err = os.Remove(filepath.Join(sdir, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSetsIndex(w *bufio.Writer, sets []SocSet) {
+	fmt.Fprintln(w, "set,name,ids,domains")
+	for _, s := range sets {
+		ids := make([]string, len(s.IDs))
+		for i, id := range s.IDs {
+			ids[i] = strconv.Itoa(id)
+		}
+		fmt.Fprintf(w, "%s,%q,%s,%s\n", s.Set, s.Name, strings.Join(ids, " "), strings.Join(s.Domains, " "))
+	}
+}
+
+// writeIpset ipset restore -! < social.ipset
+func writeIpset(w *bufio.Writer, sets []SocSet) {
+	for _, s := range sets {
+		for _, f := range []struct {
+			name   string
+			family string
+			nets   []string
+		}{
+			{s.Set, "inet", s.V4},
+			{s.Set + "6", "inet6", s.V6},
+		} {
+			if len(f.nets) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "create %s hash:net family %s -exist\n", f.name, f.family)
+			fmt.Fprintf(w, "flush %s\n", f.name)
+			for _, n := range f.nets {
+				fmt.Fprintf(w, "add %s %s\n", f.name, n)
+			}
+		}
+	}
+}
+
+// writeNft nft -f social.nft, table inet rkn_social is recreated
+func writeNft(w *bufio.Writer, sets []SocSet) {
+	fmt.Fprintln(w, "add table inet rkn_social")
+	fmt.Fprintln(w, "delete table inet rkn_social")
+	fmt.Fprintln(w, "table inet rkn_social {")
+	for _, s := range sets {
+		for _, f := range []struct {
+			name string
+			typ  string
+			nets []string
+		}{
+			{s.Set, "ipv4_addr", s.V4},
+			{s.Set + "6", "ipv6_addr", s.V6},
+		} {
+			if len(f.nets) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\t# %s\n", s.Name)
+			fmt.Fprintf(w, "\tset %s {\n\t\ttype %s\n\t\tflags interval\n", f.name, f.typ)
+			fmt.Fprintf(w, "\t\telements = { %s }\n\t}\n", strings.Join(f.nets, ", "))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// writeRouterOS /import social.rsc, address lists named by set
+func writeRouterOS(w *bufio.Writer, sets []SocSet) {
+	for _, s := range sets {
+		for _, f := range []struct {
+			path string
+			nets []string
+		}{
+			{"/ip firewall address-list", s.V4},
+			{"/ipv6 firewall address-list", s.V6},
+		} {
+			fmt.Fprintf(w, "%s remove [find list=%s]\n", f.path, s.Set)
+			for _, n := range f.nets {
+				fmt.Fprintf(w, "%s add list=%s address=%s comment=%q\n", f.path, s.Set, n, s.Name)
+			}
+		}
+	}
+}
+
+func writeLines(fn string, l []string) error {
+	return writeWith(fn, func(w *bufio.Writer) {
+		for _, s := range l {
+			fmt.Fprintln(w, s)
+		}
+	})
+}
+
+// writeWith write file through temporary file, so readers and failed
+// writes never leave partial file
+func writeWith(fn string, f func(*bufio.Writer)) error {
+	return atomicfile.Write(fn, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		f(bw)
+		return bw.Flush()
+	})
+}
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetName(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{"ВКонтакте", "soc_vkontakte"},
+		{"Госуслуги", "soc_gosuslugi"},
+		{"Щука и ёж", "soc_shchuka_i_ezh"},
+		{"Объявления", "soc_obyavleniya"},
+		{"VK.com", "soc_vk_com"},
+		{"  --Mail.ru  Group-- ", "soc_mail_ru_group"},
+		{"123", "soc_123"},
+		{"!!!", "soc"},
+		{"Очень длинное название социально значимого ресурса", "soc_ochen_dlinnoe_nazvanie_sot"},
+		{"aaaaaaaaaaaaaaaaaaaaaaaaa b", "soc_aaaaaaaaaaaaaaaaaaaaaaaaa"},
+	} {
+		got := SetName(tt.in)
+		if got != tt.want {
+			t.Errorf("SetName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if len(got) > maxSetName {
+			t.Errorf("SetName(%q) = %q longer than %d", tt.in, got, maxSetName)
+		}
+	}
+}
+
+func socDB(recs ...SocRecord) *DB {
+	db := NewDB()
+	for _, r := range recs {
+		db.ParseSoc(r)
+	}
+	return db
+}
+
+func TestSocSets(t *testing.T) {
+	db := socDB(
+		SocRecord{ID: 3, Name: "ВКонтакте", Domain: "vk.com", Subnets: []string{"87.240.128.0/19", "87.240.160.0/19", "2a00:bdc0::/36"}},
+		SocRecord{ID: 1, Name: "ВКонтакте", Domain: "vk.ru", Subnets: []string{"95.142.192.0/20"}},
+		SocRecord{ID: 2, Name: "", Domain: "noname.example"},
+	)
+	sets := db.SocSets()
+	want := []SocSet{
+		{Set: "soc_2", Name: "2", IDs: []int{2}, Domains: []string{"noname.example"}},
+		{
+			Set: "soc_vkontakte", Name: "ВКонтакте", IDs: []int{1, 3},
+			Domains: []string{"vk.com", "vk.ru"},
+			V4:      []string{"87.240.128.0/18", "95.142.192.0/20"},
+			V6:      []string{"2a00:bdc0::/36"},
+		},
+	}
+	if !reflect.DeepEqual(sets, want) {
+		t.Errorf("SocSets\n got %+v\nwant %+v", sets, want)
+	}
+}
+
+func TestSocSetsCollisions(t *testing.T) {
+	for _, names := range [][]string{
+		// ipv6 set of VK is soc_vk6
+		{"VK", "vk6"},
+		{"vk6", "VK"},
+		// same transliteration, fallback with id must be checked too
+		{"VK!", "VK?", "VK 9"},
+		{"Вк", "VK", "vk_1", "vk 1 6"},
+	} {
+		db := NewDB()
+		for i, n := range names {
+			db.ParseSoc(SocRecord{ID: i + 1, Name: n, Subnets: []string{"10.0.0.0/8", "fd00::/8"}})
+		}
+		sets := db.SocSets()
+		if len(sets) != len(names) {
+			t.Fatalf("%q: %d sets", names, len(sets))
+		}
+		seen := make(map[string]string)
+		for _, s := range sets {
+			for _, n := range []string{s.Set, s.Set + "6"} {
+				if other, ok := seen[n]; ok {
+					t.Errorf("%q: set %s of %q clashes with %q", names, n, s.Name, other)
+				}
+				seen[n] = s.Name
+			}
+			if len(s.Set) > maxSetName {
+				t.Errorf("%q: set %s longer than %d", names, s.Set, maxSetName)
+			}
+		}
+	}
+}
+
+func TestWriteSocialSets(t *testing.T) {
+	dir := t.TempDir()
+	sdir := filepath.Join(dir, SocSetsDir)
+	err := os.MkdirAll(sdir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{"soc_gone.txt", "soc_gone.domains.txt", "notes.txt"} {
+		os.WriteFile(filepath.Join(sdir, fn), nil, 0644) // nolint
+	}
+	db := socDB(
+		SocRecord{ID: 1, Name: "VK", Domain: "vk.com", Subnets: []string{"87.240.128.0/18", "2a00:bdc0::/36"}},
+		SocRecord{ID: 2, Name: "vk6", Subnets: []string{"95.142.192.0/20"}},
+	)
+	err = db.WriteSocialSets(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	des, _ := os.ReadDir(sdir)
+	for _, de := range des {
+		files = append(files, de.Name())
+	}
+	want := []string{
+		"notes.txt", "sets.csv", "soc_vk.domains.txt", "soc_vk.txt",
+		"soc_vk6_2.domains.txt", "soc_vk6_2.txt", "social.ipset", "social.nft", "social.rsc",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files %v, want %v", files, want)
+	}
+	b, _ := os.ReadFile(filepath.Join(sdir, "social.nft"))
+	for _, set := range []string{"soc_vk", "soc_vk6", "soc_vk6_2"} {
+		if n := strings.Count(string(b), "\tset "+set+" {"); n != 1 {
+			t.Errorf("social.nft declares %s %d times", set, n)
+		}
+	}
+	b, _ = os.ReadFile(filepath.Join(sdir, "soc_vk.txt"))
+	if string(b) != "87.240.128.0/18\n2a00:bdc0::/36\n" {
+		t.Errorf("soc_vk.txt %q", b)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prgra/rkndaemon/daemon"
+	"github.com/prgra/rkndaemon/downloader"
+)
+
+// replayTimeFormats accepted by replay, local time
+var replayTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// replayCmd regenerate outputs from archived dump
+func replayCmd(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	out := fs.String("o", "replay", "output directory")
+	list := fs.Bool("l", false, "list archived dumps")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: rkndaemon replay [-o outdir] <time|archive.zip>")
+		fmt.Fprintln(fs.Output(), "       rkndaemon replay -l")
+		fmt.Fprintln(fs.Output(), "time: 2006-01-02, 2006-01-02T15:04 or RFC3339, newest dump at or before it is used")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint
+	var cfg daemon.Config
+	err := cfg.LoadLocal()
+	if err != nil {
+		return err
+	}
+	app, err := daemon.NewOffline(cfg)
+	if err != nil {
+		return err
+	}
+	if *list {
+		if app.Archive == nil {
+			return fmt.Errorf("archive disabled")
+		}
+		for _, kind := range []string{"dump", "social"} {
+			entries, err := app.Archive.List(kind)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				fmt.Printf("%s\t%s\t%s\t%s\n", e.Kind, e.Time.Local().Format(time.RFC3339), downloader.ByteCountIEC(e.Size), e.Path)
+			}
+		}
+		return nil
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	arg := fs.Arg(0)
+	ctx := context.Background()
+	if _, err := os.Stat(arg); err == nil {
+		return app.ReplayFile(ctx, arg, *out)
+	}
+	for _, f := range replayTimeFormats {
+		t, err := time.ParseInLocation(f, arg, time.Local)
+		if err != nil {
+			continue
+		}
+		if f == "2006-01-02" {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		err = app.Replay(ctx, t, *out)
+		if err == nil {
+			log.Println("outputs written to", *out)
+		}
+		return err
+	}
+	return fmt.Errorf("%s is neither file nor time", arg)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/prgra/rkndaemon/daemon"
+)
+
+// resolveCmd resolve hosts with configured dns servers and filter and
+// print answers without touching resolver cache and outputs
+func resolveCmd(args []string) error {
+	var cfg daemon.Config
+	err := cfg.LoadLocal()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("resolve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: rkndaemon resolve <host>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	res, err := cfg.NewResolver()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	for r := range res.Resolve(ctx, fs.Args()) {
+		if len(r.Answers) == 0 && len(r.Dropped) == 0 {
+			fmt.Printf("%s\tno answers\n", r.Host)
+		}
+		if len(r.CNAMEs) > 0 {
+			fmt.Printf("%s\tcname\t%s\n", r.Host, strings.Join(r.CNAMEs, " "))
+		}
+		for _, a := range r.Answers {
+			fmt.Printf("%s\t%s\t%s\tttl %d\n", r.Host, a.IP, a.Server, a.TTL)
+		}
+		for _, d := range r.Dropped {
+			fmt.Printf("%s\t%s\t%s\tdropped %s\n", r.Host, d.IP, d.Server, d.Reason)
+		}
+	}
+	return ctx.Err()
+}
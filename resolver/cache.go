@@ -0,0 +1,429 @@
+package resolver
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prgra/rkndaemon/atomicfile"
+)
+
+// Cache persistent domain to ip store, ips are kept for grace period
+// after they stop being returned
+type Cache struct {
+	mu      sync.Mutex
+	Domains map[string]*CacheDomain `json:"domains"`
+}
+
+// CacheDomain resolved ips of one domain
+type CacheDomain struct {
+	Resolved time.Time           `json:"resolved"`
+	IPs      map[string]*CacheIP `json:"ips"`
+	CNAMEs   []string            `json:"cnames,omitempty"`
+	CNAMETTL uint32              `json:"cname_ttl,omitempty"`
+	// Suspicious answers dropped by filter, by ip
+	Suspicious map[string]*CacheSuspicious `json:"suspicious,omitempty"`
+}
+
+// CacheSuspicious dropped answer of domain, Count is how many times it
+// was returned
+type CacheSuspicious struct {
+	Reason   string    `json:"reason"`
+	Server   string    `json:"resolver"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// CacheIP one answer for domain
+type CacheIP struct {
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	TTL       uint32    `json:"ttl"`
+	Server    string    `json:"resolver"`
+}
+
+// NewCache create empty cache
+func NewCache() *Cache {
+	return &Cache{Domains: make(map[string]*CacheDomain)}
+}
+
+// LoadCache load cache from json file, missing file gives empty cache
+func LoadCache(fn string) (*Cache, error) {
+	c := NewCache()
+	b, err := os.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, c)
+	if c.Domains == nil {
+		c.Domains = make(map[string]*CacheDomain)
+	}
+	return c, err
+}
+
+// Save write cache to json file
+func (c *Cache) Save(fn string) error {
+	c.mu.Lock()
+	b, err := json.Marshal(c)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(fn, b)
+}
+
+// Update store answers and cname chain of result resolved at t
+func (c *Cache) Update(res Result, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.Domains[res.Host]
+	if !ok {
+		d = &CacheDomain{IPs: make(map[string]*CacheIP)}
+		c.Domains[res.Host] = d
+	}
+	d.Resolved = t
+	d.CNAMEs = res.CNAMEs
+	d.CNAMETTL = res.CNAMETTL
+	for _, a := range res.Answers {
+		ip := a.IP.String()
+		ci, ok := d.IPs[ip]
+		if !ok {
+			ci = &CacheIP{FirstSeen: t}
+			d.IPs[ip] = ci
+		}
+		ci.LastSeen = t
+		ci.TTL = a.TTL
+		ci.Server = a.Server
+	}
+	for _, a := range res.Dropped {
+		ip := a.IP.String()
+		// answer cached before it was filtered
+		delete(d.IPs, ip)
+		if !Suspicious(a.Reason) {
+			continue
+		}
+		if d.Suspicious == nil {
+			d.Suspicious = make(map[string]*CacheSuspicious)
+		}
+		cs, ok := d.Suspicious[ip]
+		if !ok {
+			cs = &CacheSuspicious{}
+			d.Suspicious[ip] = cs
+		}
+		cs.Reason = a.Reason
+		cs.Server = a.Server
+		cs.Count++
+		cs.LastSeen = t
+	}
+}
+
+// Outdated split hosts into never resolved and resolved before t
+func (c *Cache) Outdated(hosts []string, before time.Time) (unknown []string, stale []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, h := range hosts {
+		d, ok := c.Domains[h]
+		switch {
+		case !ok:
+			unknown = append(unknown, h)
+		case d.Resolved.Before(before):
+			stale = append(stale, h)
+		}
+	}
+	return unknown, stale
+}
+
+// Oldest returns up to n hosts resolved before t, oldest and never
+// resolved first
+func (c *Cache) Oldest(hosts []string, before time.Time, n int) []string {
+	c.mu.Lock()
+	resolved := make(map[string]time.Time, len(hosts))
+	var res []string
+	for _, h := range hosts {
+		d, ok := c.Domains[h]
+		if ok && !d.Resolved.Before(before) {
+			continue
+		}
+		if ok {
+			resolved[h] = d.Resolved
+		}
+		res = append(res, h)
+	}
+	c.mu.Unlock()
+	sort.SliceStable(res, func(i, j int) bool {
+		return resolved[res[i]].Before(resolved[res[j]])
+	})
+	if n < len(res) {
+		res = res[:n]
+	}
+	return res
+}
+
+// Len returns count of cached domains
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.Domains)
+}
+
+// active ip is still valid at now
+func (ci *CacheIP) active(now time.Time, grace time.Duration) bool {
+	return now.Before(ci.LastSeen.Add(time.Duration(ci.TTL)*time.Second + grace))
+}
+
+// IPs returns sorted ips of all domains active at now
+func (c *Cache) IPs(now time.Time, grace time.Duration) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]bool)
+	for _, d := range c.Domains {
+		for ip, ci := range d.IPs {
+			if ci.active(now, grace) {
+				m[ip] = true
+			}
+		}
+	}
+	res := make([]string, 0, len(m))
+	for ip := range m {
+		res = append(res, ip)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// CNAMEs returns sorted active cname targets
+func (c *Cache) CNAMEs(now time.Time, grace time.Duration) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]bool)
+	for _, d := range c.Domains {
+		if !now.Before(d.Resolved.Add(time.Duration(d.CNAMETTL)*time.Second + grace)) {
+			continue
+		}
+		for _, cn := range d.CNAMEs {
+			m[cn] = true
+		}
+	}
+	res := make([]string, 0, len(m))
+	for cn := range m {
+		res = append(res, cn)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Prune remove expired ips, suspicious answers missing in last resolve
+// and not seen for grace and domains without ips and suspicious answers
+func (c *Cache) Prune(now time.Time, grace time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, d := range c.Domains {
+		for ip, ci := range d.IPs {
+			if !ci.active(now, grace) {
+				delete(d.IPs, ip)
+			}
+		}
+		for ip, cs := range d.Suspicious {
+			if cs.LastSeen.Before(d.Resolved) && !now.Before(cs.LastSeen.Add(grace)) {
+				delete(d.Suspicious, ip)
+			}
+		}
+		if len(d.IPs) == 0 && len(d.Suspicious) == 0 && !now.Before(d.Resolved.Add(time.Duration(d.CNAMETTL)*time.Second+grace)) {
+			delete(c.Domains, name)
+		}
+	}
+}
+
+// Retain remove domains missing in hosts, returns count of removed
+func (c *Cache) Retain(hosts []string) int {
+	keep := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		keep[h] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for name := range c.Domains {
+		if !keep[name] {
+			delete(c.Domains, name)
+			n++
+		}
+	}
+	return n
+}
+
+// MapEntry domain to ip mapping record
+type MapEntry struct {
+	Domain    string    `json:"domain"`
+	CNAMEs    []string  `json:"cnames,omitempty"`
+	IP        string    `json:"ip"`
+	Server    string    `json:"resolver"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Map returns active domain to ip records sorted by domain and ip
+func (c *Cache) Map(now time.Time, grace time.Duration) []MapEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res []MapEntry
+	for name, d := range c.Domains {
+		for ip, ci := range d.IPs {
+			if !ci.active(now, grace) {
+				continue
+			}
+			res = append(res, MapEntry{
+				Domain:    name,
+				CNAMEs:    d.CNAMEs,
+				IP:        ip,
+				Server:    ci.Server,
+				FirstSeen: ci.FirstSeen,
+				LastSeen:  ci.LastSeen,
+			})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Domain != res[j].Domain {
+			return res[i].Domain < res[j].Domain
+		}
+		return res[i].IP < res[j].IP
+	})
+	return res
+}
+
+// Lookup returns active records for ip
+func (c *Cache) Lookup(ip string, now time.Time, grace time.Duration) []MapEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res []MapEntry
+	for name, d := range c.Domains {
+		ci, ok := d.IPs[ip]
+		if !ok || !ci.active(now, grace) {
+			continue
+		}
+		res = append(res, MapEntry{
+			Domain:    name,
+			CNAMEs:    d.CNAMEs,
+			IP:        ip,
+			Server:    ci.Server,
+			FirstSeen: ci.FirstSeen,
+			LastSeen:  ci.LastSeen,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Domain < res[j].Domain
+	})
+	return res
+}
+
+// LookupDomain returns active records for domain
+func (c *Cache) LookupDomain(domain string, now time.Time, grace time.Duration) []MapEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res []MapEntry
+	d, ok := c.Domains[domain]
+	if !ok {
+		return nil
+	}
+	for ip, ci := range d.IPs {
+		if !ci.active(now, grace) {
+			continue
+		}
+		res = append(res, MapEntry{
+			Domain:    domain,
+			CNAMEs:    d.CNAMEs,
+			IP:        ip,
+			Server:    ci.Server,
+			FirstSeen: ci.FirstSeen,
+			LastSeen:  ci.LastSeen,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].IP < res[j].IP
+	})
+	return res
+}
+
+// SuspiciousEntry suspicious answer of domain, Total is count of all
+// suspicious answers of domain
+type SuspiciousEntry struct {
+	Domain string `json:"domain"`
+	Total  int    `json:"total"`
+	IP     string `json:"ip"`
+	CacheSuspicious
+}
+
+// Suspicious returns suspicious answers, domains with most suspicious
+// answers first
+func (c *Cache) Suspicious() []SuspiciousEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res []SuspiciousEntry
+	for name, d := range c.Domains {
+		total := 0
+		for _, cs := range d.Suspicious {
+			total += cs.Count
+		}
+		for ip, cs := range d.Suspicious {
+			res = append(res, SuspiciousEntry{Domain: name, Total: total, IP: ip, CacheSuspicious: *cs})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Total != res[j].Total {
+			return res[i].Total > res[j].Total
+		}
+		if res[i].Domain != res[j].Domain {
+			return res[i].Domain < res[j].Domain
+		}
+		return res[i].IP < res[j].IP
+	})
+	return res
+}
+
+// WriteSuspicious write suspicious answers as csv
+func (c *Cache) WriteSuspicious(fn string) error {
+	entries := c.Suspicious()
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write([]string{"domain", "total", "ip", "reason", "resolver", "count", "last_seen"}) // nolint
+		for _, e := range entries {
+			w.Write([]string{e.Domain, strconv.Itoa(e.Total), e.IP, e.Reason, e.Server, strconv.Itoa(e.Count), e.LastSeen.Format(time.RFC3339)}) // nolint
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// WriteMap write active mapping as json and csv
+func (c *Cache) WriteMap(jsonfn string, csvfn string, now time.Time, grace time.Duration) error {
+	m := c.Map(now, grace)
+	b, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		return err
+	}
+	err = atomicfile.Write(jsonfn, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(csvfn, func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write([]string{"domain", "ip", "resolver", "first_seen", "last_seen", "cnames"}) // nolint
+		for _, e := range m {
+			w.Write([]string{e.Domain, e.IP, e.Server, e.FirstSeen.Format(time.RFC3339), e.LastSeen.Format(time.RFC3339), strings.Join(e.CNAMEs, " ")}) // nolint
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
@@ -0,0 +1,162 @@
+package resolver
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func cacheAnswer(ip string, ttl uint32) Answer {
+	return Answer{IP: net.ParseIP(ip), TTL: ttl, Server: "udp://192.0.2.53:53"}
+}
+
+func TestCacheUpdate(t *testing.T) {
+	c := NewCache()
+	c.Update(Result{Host: "a.example", Answers: []Answer{cacheAnswer("1.1.1.1", 60), cacheAnswer("2.2.2.2", 60)}}, t0)
+	c.Update(Result{Host: "a.example", Answers: []Answer{cacheAnswer("2.2.2.2", 300), cacheAnswer("3.3.3.3", 30)}}, t0.Add(time.Hour))
+	d := c.Domains["a.example"]
+	if !d.Resolved.Equal(t0.Add(time.Hour)) {
+		t.Errorf("resolved %s", d.Resolved)
+	}
+	for _, tt := range []struct {
+		ip    string
+		first time.Time
+		last  time.Time
+		ttl   uint32
+	}{
+		// answers missing in later resolve are kept
+		{"1.1.1.1", t0, t0, 60},
+		{"2.2.2.2", t0, t0.Add(time.Hour), 300},
+		{"3.3.3.3", t0.Add(time.Hour), t0.Add(time.Hour), 30},
+	} {
+		ci, ok := d.IPs[tt.ip]
+		if !ok {
+			t.Errorf("%s not accumulated", tt.ip)
+			continue
+		}
+		if !ci.FirstSeen.Equal(tt.first) || !ci.LastSeen.Equal(tt.last) || ci.TTL != tt.ttl {
+			t.Errorf("%s: first %s last %s ttl %d, want %s %s %d", tt.ip, ci.FirstSeen, ci.LastSeen, ci.TTL, tt.first, tt.last, tt.ttl)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	const grace = time.Hour
+	c := NewCache()
+	c.Update(Result{Host: "a.example", Answers: []Answer{cacheAnswer("1.1.1.1", 60), cacheAnswer("2.2.2.2", 3600)}}, t0)
+	c.Update(Result{Host: "b.example", Answers: []Answer{cacheAnswer("3.3.3.3", 60)}, CNAMEs: []string{"b.cdn.example"}, CNAMETTL: 60}, t0)
+	for _, tt := range []struct {
+		at     time.Duration
+		ips    []string
+		cnames []string
+	}{
+		{0, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, []string{"b.cdn.example"}},
+		// ttl + grace is still active
+		{time.Hour + 59*time.Second, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, []string{"b.cdn.example"}},
+		{time.Hour + time.Minute, []string{"2.2.2.2"}, []string{}},
+		{2 * time.Hour, []string{}, []string{}},
+	} {
+		now := t0.Add(tt.at)
+		if got := c.IPs(now, grace); !reflect.DeepEqual(got, tt.ips) {
+			t.Errorf("at %s: ips %v, want %v", tt.at, got, tt.ips)
+		}
+		if got := c.CNAMEs(now, grace); !reflect.DeepEqual(got, tt.cnames) {
+			t.Errorf("at %s: cnames %v, want %v", tt.at, got, tt.cnames)
+		}
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	const grace = time.Hour
+	c := NewCache()
+	c.Update(Result{Host: "a.example", Answers: []Answer{cacheAnswer("1.1.1.1", 60), cacheAnswer("2.2.2.2", 7200)}}, t0)
+	c.Update(Result{Host: "b.example", Answers: []Answer{cacheAnswer("3.3.3.3", 60)}}, t0)
+	c.Update(Result{Host: "c.example", CNAMEs: []string{"c.cdn.example"}, CNAMETTL: 7200}, t0)
+	c.Prune(t0.Add(90*time.Minute), grace)
+	if _, ok := c.Domains["b.example"]; ok {
+		t.Error("domain without active ips kept")
+	}
+	if ips := c.Domains["a.example"].IPs; len(ips) != 1 || ips["2.2.2.2"] == nil {
+		t.Errorf("a.example ips after prune %v", ips)
+	}
+	if _, ok := c.Domains["c.example"]; !ok {
+		t.Error("domain with active cname removed")
+	}
+	c.Prune(t0.Add(4*time.Hour), grace)
+	if c.Len() != 0 {
+		t.Errorf("%d domains left after everything expired", c.Len())
+	}
+}
+
+func TestCacheRetain(t *testing.T) {
+	c := NewCache()
+	for _, h := range []string{"a.example", "b.example", "c.example"} {
+		c.Update(Result{Host: h, Answers: []Answer{cacheAnswer("1.1.1.1", 60)}}, t0)
+	}
+	n := c.Retain([]string{"a.example", "c.example", "new.example"})
+	if n != 1 || c.Len() != 2 || c.Domains["b.example"] != nil {
+		t.Errorf("removed %d, left %d", n, c.Len())
+	}
+	if n = c.Retain(nil); n != 2 || c.Len() != 0 {
+		t.Errorf("retain nothing removed %d, left %d", n, c.Len())
+	}
+}
+
+func TestCacheOutdated(t *testing.T) {
+	c := NewCache()
+	c.Update(Result{Host: "old.example"}, t0)
+	c.Update(Result{Host: "fresh.example"}, t0.Add(2*time.Hour))
+	unknown, stale := c.Outdated([]string{"new.example", "old.example", "fresh.example"}, t0.Add(time.Hour))
+	if !reflect.DeepEqual(unknown, []string{"new.example"}) || !reflect.DeepEqual(stale, []string{"old.example"}) {
+		t.Errorf("unknown %v, stale %v", unknown, stale)
+	}
+}
+
+func TestCacheOldest(t *testing.T) {
+	c := NewCache()
+	c.Update(Result{Host: "b.example"}, t0.Add(2*time.Minute))
+	c.Update(Result{Host: "a.example"}, t0.Add(time.Minute))
+	c.Update(Result{Host: "c.example"}, t0.Add(3*time.Minute))
+	c.Update(Result{Host: "fresh.example"}, t0.Add(time.Hour))
+	hosts := []string{"fresh.example", "c.example", "new1.example", "b.example", "a.example", "new2.example"}
+	before := t0.Add(30 * time.Minute)
+	for _, tt := range []struct {
+		n    int
+		want []string
+	}{
+		// never resolved first in given order, then oldest
+		{10, []string{"new1.example", "new2.example", "a.example", "b.example", "c.example"}},
+		{3, []string{"new1.example", "new2.example", "a.example"}},
+		{0, []string{}},
+	} {
+		got := c.Oldest(hosts, before, tt.n)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Oldest n %d = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCacheSaveLoad(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "resolver_cache.json")
+	c := NewCache()
+	c.Update(Result{Host: "a.example", Answers: []Answer{cacheAnswer("1.1.1.1", 60)}, CNAMEs: []string{"a.cdn.example"}, CNAMETTL: 30}, t0)
+	err := c.Save(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadCache(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(l.Domains, c.Domains) {
+		t.Errorf("loaded %+v, want %+v", l.Domains["a.example"], c.Domains["a.example"])
+	}
+	l, err = LoadCache(fn + ".missing")
+	if err != nil || l.Len() != 0 {
+		t.Errorf("missing cache: %d domains, err %v", l.Len(), err)
+	}
+}
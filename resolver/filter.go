@@ -0,0 +1,104 @@
+package resolver
+
+import (
+	"net"
+
+	"github.com/prgra/rkndaemon/parser"
+)
+
+// reasons of dropped answers
+const (
+	DropNonGlobal = "nonglobal"
+	DropBogon     = "bogon"
+	DropSinkhole  = "sinkhole"
+	DropWhitelist = "whitelist"
+)
+
+// bogons private, shared, documentation and reserved networks
+var bogons = mustNets(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"100::/64",
+	"2001:db8::/32",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// Filter drops answers which must not get into results: not global
+// unicast, bogons, sinkholes (stub ips of filtering resolvers) and
+// whitelisted networks. Nil filter checks only builtin lists
+type Filter struct {
+	Whitelist []*net.IPNet
+	Sinkholes []*net.IPNet
+}
+
+// Dropped answer removed by filter
+type Dropped struct {
+	IP     net.IP
+	Server string
+	Reason string
+}
+
+// NewFilter create filter, entries are ips or cidrs
+func NewFilter(whitelist []string, sinkholes []string) (*Filter, error) {
+	var f Filter
+	var err error
+	f.Whitelist, err = parser.ParseNetsStrict(whitelist)
+	if err != nil {
+		return nil, err
+	}
+	f.Sinkholes, err = parser.ParseNetsStrict(sinkholes)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Check returns reason why ip is dropped, empty if ip is good
+func (f *Filter) Check(ip net.IP) string {
+	if !ip.IsGlobalUnicast() {
+		return DropNonGlobal
+	}
+	if parser.Contains(bogons, ip) {
+		return DropBogon
+	}
+	if f == nil {
+		return ""
+	}
+	if parser.Contains(f.Sinkholes, ip) {
+		return DropSinkhole
+	}
+	if parser.Contains(f.Whitelist, ip) {
+		return DropWhitelist
+	}
+	return ""
+}
+
+// Suspicious reason means poisoned or parked name, whitelisted
+// addresses are legal answers
+func Suspicious(reason string) bool {
+	return reason != "" && reason != DropWhitelist
+}
+
+func mustNets(l ...string) []*net.IPNet {
+	res, err := parser.ParseNetsStrict(l)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
@@ -0,0 +1,141 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestFilterCheck(t *testing.T) {
+	f, err := NewFilter([]string{"77.88.0.0/16", " 2a02:6b8::1 "}, []string{"93.158.134.3", "", "2a00:bdc0::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		ip   string
+		want string
+	}{
+		{"8.8.8.8", ""},
+		{"2a00:1450::1", ""},
+		{"127.0.0.1", DropNonGlobal},
+		{"::1", DropNonGlobal},
+		{"0.0.0.0", DropNonGlobal},
+		{"224.0.0.1", DropNonGlobal},
+		{"169.254.1.1", DropNonGlobal},
+		{"fe80::1", DropNonGlobal},
+		{"10.1.2.3", DropBogon},
+		{"172.16.0.1", DropBogon},
+		{"192.168.1.1", DropBogon},
+		{"100.64.0.1", DropBogon},
+		{"198.51.100.7", DropBogon},
+		{"fd00::1", DropBogon},
+		{"2001:db8::1", DropBogon},
+		{"::ffff:10.0.0.1", DropBogon},
+		{"93.158.134.3", DropSinkhole},
+		{"2a00:bdc0::5", DropSinkhole},
+		{"77.88.8.8", DropWhitelist},
+		{"2a02:6b8::1", DropWhitelist},
+		{"2a02:6b8::2", ""},
+	} {
+		if got := f.Check(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Check(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	// nil filter checks only builtin lists
+	var nf *Filter
+	if got := nf.Check(net.ParseIP("93.158.134.3")); got != "" {
+		t.Errorf("nil filter dropped sinkhole: %s", got)
+	}
+	if got := nf.Check(net.ParseIP("10.0.0.1")); got != DropBogon {
+		t.Errorf("nil filter kept bogon: %s", got)
+	}
+
+	for _, bad := range [][]string{{"bad"}, {"10.0.0.0/33"}} {
+		if _, err := NewFilter(bad, nil); err == nil {
+			t.Errorf("NewFilter(%q) accepted", bad)
+		}
+		if _, err := NewFilter(nil, bad); err == nil {
+			t.Errorf("NewFilter sinkholes %q accepted", bad)
+		}
+	}
+}
+
+func TestResolveHostFilter(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := map[uint16][]string{
+		dns.TypeA:    {"8.8.8.8", "127.0.0.1", "10.0.0.1", "93.158.134.3", "77.88.8.8"},
+		dns.TypeAAAA: {"::1", "2a00:1450::1"},
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		for _, ip := range records[q.Qtype] {
+			rr, _ := dns.NewRR(q.Name + " 60 IN " + dns.TypeToString[q.Qtype] + " " + ip)
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m) // nolint
+	})
+	srv := &dns.Server{PacketConn: pc, Net: "udp", Handler: handler}
+	go srv.ActivateAndServe() // nolint
+	defer srv.Shutdown()      // nolint
+
+	pool, err := NewPool([]string{pc.LocalAddr().String()}, PoolOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(pool)
+	r.System = false
+	r.Filter, err = NewFilter([]string{"77.88.0.0/16"}, []string{"93.158.134.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := r.ResolveHost(context.Background(), "example.com")
+	var good []string
+	for _, a := range res.Answers {
+		good = append(good, a.IP.String())
+	}
+	sort.Strings(good)
+	if want := []string{"2a00:1450::1", "8.8.8.8"}; !reflect.DeepEqual(good, want) {
+		t.Errorf("answers %v, want %v", good, want)
+	}
+	dropped := make(map[string]string)
+	for _, d := range res.Dropped {
+		dropped[d.IP.String()] = d.Reason
+	}
+	want := map[string]string{
+		"127.0.0.1":    DropNonGlobal,
+		"::1":          DropNonGlobal,
+		"10.0.0.1":     DropBogon,
+		"93.158.134.3": DropSinkhole,
+		"77.88.8.8":    DropWhitelist,
+	}
+	if !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped %v, want %v", dropped, want)
+	}
+
+	// dropped answers don't get into cache, whitelisted are not suspicious
+	c := NewCache()
+	c.Update(Result{Host: "example.com", Answers: []Answer{cacheAnswer("10.0.0.1", 60)}}, t0)
+	c.Update(res, t0.Add(time.Minute))
+	if got := c.IPs(t0.Add(time.Minute), 0); !reflect.DeepEqual(got, []string{"2a00:1450::1", "8.8.8.8"}) {
+		t.Errorf("cached ips %v", got)
+	}
+	var suspicious []string
+	for _, s := range c.Suspicious() {
+		suspicious = append(suspicious, s.IP+" "+s.Reason)
+	}
+	sort.Strings(suspicious)
+	if want := []string{"10.0.0.1 bogon", "127.0.0.1 nonglobal", "93.158.134.3 sinkhole", "::1 nonglobal"}; !reflect.DeepEqual(suspicious, want) {
+		t.Errorf("suspicious %v, want %v", suspicious, want)
+	}
+}
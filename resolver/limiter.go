@@ -0,0 +1,48 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter spread calls evenly to not more than qps per second
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter create limiter, nil limiter (qps <= 0) does not limit
+func NewLimiter(qps int) *Limiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &Limiter{interval: time.Second / time.Duration(qps)}
+}
+
+// Wait until next call allowed or ctx done
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
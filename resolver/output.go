@@ -0,0 +1,43 @@
+package resolver
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prgra/rkndaemon/parser"
+)
+
+// WriteFiles write all active cached ipv4 to fn, ipv6 to fn with 6
+// suffix (resolved6.txt), cname targets to resolved_cnames.txt, domain
+// to ip mapping as resolved_map.json and resolved_map.csv and suspicious
+// answers as resolved_suspicious.csv next to fn
+func WriteFiles(c *Cache, fn string, now time.Time, grace time.Duration) {
+	list := make(parser.List)
+	list6 := make(parser.List)
+	for _, ip := range c.IPs(now, grace) {
+		if strings.Contains(ip, ":") {
+			list6.Add(ip)
+		} else {
+			list.Add(ip)
+		}
+	}
+	list.WriteFile(fn)
+	ext := filepath.Ext(fn)
+	list6.WriteFile(strings.TrimSuffix(fn, ext) + "6" + ext)
+	dir := filepath.Dir(fn)
+	cnames := make(parser.List)
+	for _, cn := range c.CNAMEs(now, grace) {
+		cnames.Add(cn)
+	}
+	cnames.WriteFile(filepath.Join(dir, "resolved_cnames.txt"))
+	err := c.WriteMap(filepath.Join(dir, "resolved_map.json"), filepath.Join(dir, "resolved_map.csv"), now, grace)
+	if err != nil {
+		log.Println("can't write resolved map", err)
+	}
+	err = c.WriteSuspicious(filepath.Join(dir, "resolved_suspicious.csv"))
+	if err != nil {
+		log.Println("can't write suspicious answers", err)
+	}
+}
@@ -0,0 +1,185 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prgra/rkndaemon/metrics"
+)
+
+func init() {
+	metrics.Describe("rkndaemon_dns_queries_total", "counter", "queries to upstream dns servers by result")
+	metrics.Describe("rkndaemon_dns_server_up", "gauge", "upstream dns server is not ejected")
+}
+
+// PoolOptions of upstream pool
+type PoolOptions struct {
+	// Timeout of one query
+	Timeout time.Duration
+	// QPS per upstream, 0 unlimited
+	QPS int
+	// MaxFails consecutive failures before upstream is ejected
+	MaxFails int
+	// EjectTime first ejection duration, doubles on repeated ejections
+	EjectTime time.Duration
+	// Retries of failed query on other upstreams
+	Retries int
+}
+
+// ServerStats statistics of one upstream
+type ServerStats struct {
+	Server       string    `json:"server"`
+	Queries      int64     `json:"queries"`
+	Errors       int64     `json:"errors"`
+	Timeouts     int64     `json:"timeouts"`
+	Score        float64   `json:"score"`
+	LatencyMs    float64   `json:"latency_ms"`
+	Ejected      bool      `json:"ejected"`
+	EjectedUntil time.Time `json:"ejected_until,omitempty"`
+}
+
+type poolServer struct {
+	up       Upstream
+	limiter  *Limiter
+	stats    ServerStats
+	fails    int
+	ejection time.Duration
+}
+
+// Pool of upstreams with per upstream rate limit and health scoring,
+// failing upstreams are ejected for a while
+type Pool struct {
+	mu      sync.Mutex
+	servers []*poolServer
+	opts    PoolOptions
+}
+
+// NewPool create pool, see ParseUpstream for server formats
+func NewPool(servers []string, opts PoolOptions) (*Pool, error) {
+	if opts.MaxFails < 1 {
+		opts.MaxFails = 5
+	}
+	if opts.EjectTime <= 0 {
+		opts.EjectTime = 30 * time.Second
+	}
+	p := &Pool{opts: opts}
+	for _, s := range servers {
+		u, err := ParseUpstream(s, opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		p.servers = append(p.servers, &poolServer{
+			up:      u,
+			limiter: NewLimiter(opts.QPS),
+			stats:   ServerStats{Server: u.String(), Score: 1},
+		})
+		metrics.Set("rkndaemon_dns_server_up", 1, "server", u.String())
+	}
+	return p, nil
+}
+
+// pick random healthy server except skip, if all are ejected the one
+// which returns first is used
+func (p *Pool) pick(skip *poolServer) *poolServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var healthy []*poolServer
+	var soonest *poolServer
+	for _, s := range p.servers {
+		if s.stats.Ejected && now.After(s.stats.EjectedUntil) {
+			s.stats.Ejected = false
+			metrics.Set("rkndaemon_dns_server_up", 1, "server", s.stats.Server)
+		}
+		if !s.stats.Ejected && s != skip {
+			healthy = append(healthy, s)
+		}
+		if soonest == nil || s.stats.EjectedUntil.Before(soonest.stats.EjectedUntil) {
+			soonest = s
+		}
+	}
+	if len(healthy) > 0 {
+		return healthy[rand.Intn(len(healthy))]
+	}
+	return soonest
+}
+
+// Exchange send query to healthy upstream, failed queries are retried on
+// other upstreams, returns answer and name of upstream
+func (p *Pool) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, string, error) {
+	if len(p.servers) == 0 {
+		return nil, "", errors.New("no dns servers")
+	}
+	var last *poolServer
+	var err error
+	for try := 0; try <= p.opts.Retries; try++ {
+		s := p.pick(last)
+		if err = ctx.Err(); err != nil {
+			return nil, s.stats.Server, err
+		}
+		err = s.limiter.Wait(ctx)
+		if err != nil {
+			return nil, s.stats.Server, err
+		}
+		start := time.Now()
+		var in *dns.Msg
+		in, err = s.up.Exchange(m)
+		p.report(s, time.Since(start), err)
+		if err == nil {
+			return in, s.stats.Server, nil
+		}
+		last = s
+	}
+	return nil, last.stats.Server, err
+}
+
+// report update health of server after query
+func (p *Pool) report(s *poolServer, d time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.stats.Queries++
+	const alpha = 0.1
+	if err == nil {
+		s.fails = 0
+		s.ejection = 0
+		s.stats.Score = s.stats.Score*(1-alpha) + alpha
+		s.stats.LatencyMs = s.stats.LatencyMs*(1-alpha) + alpha*float64(d.Microseconds())/1000
+		metrics.Add("rkndaemon_dns_queries_total", 1, "server", s.stats.Server, "result", "ok")
+		return
+	}
+	s.stats.Errors++
+	result := "error"
+	if isTimeout(err) {
+		s.stats.Timeouts++
+		result = "timeout"
+	}
+	metrics.Add("rkndaemon_dns_queries_total", 1, "server", s.stats.Server, "result", result)
+	s.stats.Score = s.stats.Score * (1 - alpha)
+	s.fails++
+	if s.fails >= p.opts.MaxFails && !s.stats.Ejected {
+		if s.ejection == 0 {
+			s.ejection = p.opts.EjectTime
+		} else if s.ejection < 10*time.Minute {
+			s.ejection *= 2
+		}
+		s.stats.Ejected = true
+		s.stats.EjectedUntil = time.Now().Add(s.ejection)
+		s.fails = 0
+		metrics.Set("rkndaemon_dns_server_up", 0, "server", s.stats.Server)
+	}
+}
+
+// Stats returns statistics of all upstreams
+func (p *Pool) Stats() []ServerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]ServerStats, 0, len(p.servers))
+	for _, s := range p.servers {
+		res = append(res, s.stats)
+	}
+	return res
+}
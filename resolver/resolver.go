@@ -1,117 +1,229 @@
 package resolver
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net"
-	"net/url"
 	"strings"
 	"sync"
 
-	"github.com/prgra/rkndaemon/parser"
-
-	"github.com/bogdanovich/dns_resolver"
+	"github.com/miekg/dns"
 )
 
+// SystemResolver is Answer.Server for answers of system resolver
+const SystemResolver = "system"
+
+// Result of resolving one host, CNAMEs are followed targets in order,
+// CNAMETTL is minimal ttl of cname records, Dropped are answers removed
+// by Filter
+type Result struct {
+	Host     string
+	Answers  []Answer
+	CNAMEs   []string
+	CNAMETTL uint32
+	Dropped  []Dropped
+}
+
+// Answer one address of host and server which returned it
+type Answer struct {
+	IP     net.IP
+	TTL    uint32
+	Server string
+}
+
+// Resolver resolves hosts with upstreams of pool, it has no state of
+// its own run, so Resolve can be called repeatedly and concurrently
 type Resolver struct {
-	inChan      chan *url.URL
-	outChan     chan []net.IP
-	waitGroup   *sync.WaitGroup
-	writerWG    *sync.WaitGroup
-	dnsResolver *dns_resolver.DnsResolver
+	pool *Pool
+	// Workers count of concurrent hosts in Resolve
+	Workers int
+	// Limiter limits hosts per second started by Resolve
+	Limiter *Limiter
+	// System additionally resolve hosts with system resolver
+	System bool
+	// Filter drops bogon, sinkhole and whitelisted answers
+	Filter *Filter
 }
 
-func New(dnsservers []string) *Resolver {
-	dnsresolver := dns_resolver.New(dnsservers)
-	dnsresolver.RetryTimes = 3
-	var mwg sync.WaitGroup
-	var wwg sync.WaitGroup
+// New create resolver querying upstreams of pool, pool can be shared
+// between resolvers to keep upstream health
+func New(pool *Pool) *Resolver {
 	return &Resolver{
-		inChan:      make(chan *url.URL),
-		outChan:     make(chan []net.IP),
-		dnsResolver: dnsresolver,
-		writerWG:    &wwg,
-		waitGroup:   &mwg,
+		pool:    pool,
+		Workers: 1,
+		System:  true,
 	}
 }
 
-func (r Resolver) AddToQueue(url *url.URL) {
-	r.inChan <- url
-}
-
-func (r Resolver) worker() {
-	for {
-		dom, ok := <-r.inChan
-		if !ok {
-			r.waitGroup.Done()
-			return
-		}
-		if dom.Hostname() == "" {
-			continue
+// Resolve hosts on Workers goroutines, results are sent to returned
+// channel, it is closed when all hosts are resolved or ctx is done.
+// Hosts not resolved before ctx is done give no result
+func (r *Resolver) Resolve(ctx context.Context, hosts []string) <-chan Result {
+	out := make(chan Result)
+	in := make(chan string)
+	workers := r.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for h := range in {
+				res := r.ResolveHost(ctx, h)
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+	feed:
+		for _, h := range hosts {
+			if h == "" {
+				continue
+			}
+			if r.Limiter.Wait(ctx) != nil {
+				break
+			}
+			select {
+			case in <- h:
+			case <-ctx.Done():
+				break feed
+			}
 		}
-		ips, err := r.dnsResolver.LookupHost(dom.Hostname())
-		if err != nil {
-			// log.Println("LookupHost", err)
+		close(in)
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
 
+// ResolveHost resolve one host with upstreams and system resolver and
+// apply Filter
+func (r *Resolver) ResolveHost(ctx context.Context, host string) Result {
+	answers, cnames, cnttl := r.resolve(ctx, host)
+	seen := make(map[string]bool)
+	var res []Answer
+	for i := range answers {
+		if !seen[answers[i].IP.String()] {
+			seen[answers[i].IP.String()] = true
+			res = append(res, answers[i])
 		}
-		ipsmap := make(map[string]bool)
-		for i := range ips {
-			if ips[i].To4() != nil {
-				ipsmap[ips[i].String()] = true
-			}
-		}
+	}
 
-		ips2, err := net.LookupHost(dom.Hostname())
+	var ips2 []string
+	if r.System {
+		var err error
+		ips2, err = net.DefaultResolver.LookupHost(ctx, host)
 		if err != nil &&
 			!strings.HasSuffix(err.Error(), "no such host") &&
 			!strings.HasSuffix(err.Error(), "server misbehaving") &&
-			!strings.HasSuffix(err.Error(), "i/o timeout") {
+			!strings.HasSuffix(err.Error(), "i/o timeout") &&
+			ctx.Err() == nil {
 			log.Println("net.LookupHost", err)
 		}
+	}
 
-		for i := range ips2 {
-			a := net.ParseIP(ips2[i])
-			if a.To4() != nil {
-				ipsmap[ips2[i]] = true
-			}
-		}
-		var res []net.IP
-		for k := range ipsmap {
-			ip := net.ParseIP(k)
-			if ip.To4() != nil {
-				res = append(res, ip)
-			}
+	for i := range ips2 {
+		a := net.ParseIP(ips2[i])
+		if a != nil && !seen[a.String()] {
+			seen[a.String()] = true
+			res = append(res, Answer{IP: a, Server: SystemResolver})
 		}
+	}
 
-		r.outChan <- res
+	good := res[:0]
+	var dropped []Dropped
+	for _, a := range res {
+		if reason := r.Filter.Check(a.IP); reason != "" {
+			dropped = append(dropped, Dropped{IP: a.IP, Server: a.Server, Reason: reason})
+			continue
+		}
+		good = append(good, a)
 	}
+	return Result{Host: host, Answers: good, CNAMEs: cnames, CNAMETTL: cnttl, Dropped: dropped}
 }
 
-func (r *Resolver) Run(workerCount int, fn string) {
-	r.waitGroup.Add(workerCount)
-	r.writerWG.Add(1)
-	go r.WriteToFile(fn)
-	for i := 0; i < workerCount; i++ {
-		go r.worker()
+// maxCNAMEDepth limit of followed cname queries for one host
+const maxCNAMEDepth = 8
+
+// resolve query A and AAAA records of host, cname targets are recorded
+// and followed when server returned no addresses for them
+func (r *Resolver) resolve(ctx context.Context, host string) (answers []Answer, cnames []string, cnttl uint32) {
+	visited := make(map[string]bool)
+	name := dns.Fqdn(strings.ToLower(host))
+	for depth := 0; depth < maxCNAMEDepth; depth++ {
+		visited[name] = true
+		last := ""
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			rrs, server, err := r.lookup(ctx, name, qtype)
+			if err != nil {
+				// log.Println("lookup", err)
+				continue
+			}
+			for _, rr := range rrs {
+				switch v := rr.(type) {
+				case *dns.A:
+					answers = append(answers, Answer{IP: v.A, TTL: v.Hdr.Ttl, Server: server})
+				case *dns.AAAA:
+					answers = append(answers, Answer{IP: v.AAAA, TTL: v.Hdr.Ttl, Server: server})
+				case *dns.CNAME:
+					last = strings.ToLower(v.Target)
+					cnames = appendUniq(cnames, strings.TrimSuffix(last, "."))
+					if cnttl == 0 || v.Hdr.Ttl < cnttl {
+						cnttl = v.Hdr.Ttl
+					}
+				}
+			}
+		}
+		if len(answers) > 0 || last == "" || ctx.Err() != nil {
+			return answers, cnames, cnttl
+		}
+		if visited[last] {
+			log.Println("cname loop for", host, "at", last)
+			return answers, cnames, cnttl
+		}
+		name = last
 	}
+	log.Println("cname chain too long for", host)
+	return answers, cnames, cnttl
 }
-func (r *Resolver) Close() {
-	close(r.inChan)
-	r.waitGroup.Wait()
-	close(r.outChan)
-	r.writerWG.Wait()
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return strings.HasSuffix(err.Error(), "i/o timeout")
 }
 
-func (r *Resolver) WriteToFile(fn string) {
-	list := make(parser.List)
-	for {
-		ips, ok := <-r.outChan
-		if !ok {
-			break
-		}
-		for i := range ips {
-			list.Add(ips[i].String())
+func appendUniq(l []string, s string) []string {
+	for i := range l {
+		if l[i] == s {
+			return l
 		}
 	}
-	list.WriteFile(fn)
-	r.writerWG.Done()
+	return append(l, s)
+}
+
+// lookup query records of qtype from pool
+func (r *Resolver) lookup(ctx context.Context, name string, qtype uint16) (rrs []dns.RR, server string, err error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	in, server, err := r.pool.Exchange(ctx, m)
+	if err != nil {
+		return nil, server, err
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return nil, server, errors.New(dns.RcodeToString[in.Rcode])
+	}
+	return in.Answer, server, nil
 }
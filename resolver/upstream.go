@@ -0,0 +1,214 @@
+package resolver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Upstream dns server used by resolver
+type Upstream interface {
+	Exchange(m *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// DefaultTimeout of one upstream query
+const DefaultTimeout = 5 * time.Second
+
+// ParseUpstream parse server address, timeout <= 0 means DefaultTimeout,
+// supported forms:
+//
+//	8.8.8.8, 8.8.8.8:53, udp://8.8.8.8:53  plain dns over udp
+//	tcp://8.8.8.8:53                       plain dns over tcp
+//	tls://dns.google, tls://1.1.1.1:853    dns over tls (RFC 7858)
+//	https://dns.google/dns-query           dns over https (RFC 8484)
+func ParseUpstream(s string, timeout time.Duration) (Upstream, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if !strings.Contains(s, "://") {
+		s = "udp://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host in dns server %q", s)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		return &dnsUpstream{
+			addr:   withPort(u.Host, "53"),
+			client: &dns.Client{Net: u.Scheme, Timeout: timeout},
+			name:   u.Scheme + "://" + withPort(u.Host, "53"),
+		}, nil
+	case "tls":
+		return &dnsUpstream{
+			addr: withPort(u.Host, "853"),
+			client: &dns.Client{
+				Net:       "tcp-tls",
+				Timeout:   timeout,
+				TLSConfig: &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12},
+			},
+			name: "tls://" + withPort(u.Host, "853"),
+		}, nil
+	case "https":
+		return &dohUpstream{
+			url:    u.String(),
+			client: &http.Client{Timeout: timeout},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown dns server scheme %q", u.Scheme)
+}
+
+func withPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// dnsUpstream plain dns or dns over tls
+type dnsUpstream struct {
+	addr   string
+	client *dns.Client
+	name   string
+
+	mu   sync.Mutex
+	idle []idleConn // dns over tls connections kept between queries
+}
+
+// idleConn connection returned to pool at time t
+type idleConn struct {
+	conn *dns.Conn
+	t    time.Time
+}
+
+const (
+	// maxIdleConns of one dns over tls upstream
+	maxIdleConns = 8
+	// idleConnTimeout servers usually close idle connections after 10s (RFC 7766)
+	idleConnTimeout = 8 * time.Second
+)
+
+func (u *dnsUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	if u.client.Net == "tcp-tls" {
+		return u.exchangeTLS(m)
+	}
+	in, _, err := u.client.Exchange(m, u.addr)
+	if err == nil && in.Truncated && u.client.Net == "udp" {
+		tcp := &dns.Client{Net: "tcp", Timeout: u.client.Timeout}
+		in, _, err = tcp.Exchange(m, u.addr)
+	}
+	return in, err
+}
+
+// exchangeTLS query over idle connection, new one is dialed when pool is empty
+// or idle connection was closed by server, timeouts are not retried
+func (u *dnsUpstream) exchangeTLS(m *dns.Msg) (*dns.Msg, error) {
+	for {
+		conn, reused := u.getConn()
+		if conn == nil {
+			var err error
+			conn, err = u.client.Dial(u.addr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		in, _, err := u.client.ExchangeWithConn(m, conn)
+		if err != nil {
+			conn.Close()
+			var ne net.Error
+			if reused && !(errors.As(err, &ne) && ne.Timeout()) {
+				continue
+			}
+			return nil, err
+		}
+		u.putConn(conn)
+		return in, nil
+	}
+}
+
+func (u *dnsUpstream) getConn() (*dns.Conn, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for len(u.idle) > 0 {
+		ic := u.idle[len(u.idle)-1]
+		u.idle = u.idle[:len(u.idle)-1]
+		if time.Since(ic.t) < idleConnTimeout {
+			return ic.conn, true
+		}
+		ic.conn.Close()
+	}
+	return nil, false
+}
+
+func (u *dnsUpstream) putConn(conn *dns.Conn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.idle) >= maxIdleConns {
+		conn.Close()
+		return
+	}
+	u.idle = append(u.idle, idleConn{conn: conn, t: time.Now()})
+}
+
+func (u *dnsUpstream) String() string {
+	return u.name
+}
+
+// dohUpstream dns over https, RFC 8484 POST
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *dohUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 recommends id 0 for cache friendliness
+	q := m.Copy()
+	q.Id = 0
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh %s: %s", u.url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	err = in.Unpack(body)
+	if err != nil {
+		return nil, err
+	}
+	in.Id = m.Id
+	return in, nil
+}
+
+func (u *dohUpstream) String() string {
+	return u.url
+}
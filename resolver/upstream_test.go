@@ -0,0 +1,256 @@
+package resolver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		in   string
+		name string
+		net  string
+	}{
+		{"8.8.8.8", "udp://8.8.8.8:53", "udp"},
+		{"8.8.8.8:5353", "udp://8.8.8.8:5353", "udp"},
+		{"udp://8.8.8.8:53", "udp://8.8.8.8:53", "udp"},
+		{"tcp://8.8.8.8", "tcp://8.8.8.8:53", "tcp"},
+		{"tls://dns.google", "tls://dns.google:853", "tcp-tls"},
+		{"tls://1.1.1.1:8853", "tls://1.1.1.1:8853", "tcp-tls"},
+		{"[2001:4860:4860::8888]", "udp://[2001:4860:4860::8888]:53", "udp"},
+		{"https://dns.google/dns-query", "https://dns.google/dns-query", ""},
+	}
+	for _, tt := range tests {
+		u, err := ParseUpstream(tt.in, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+		if u.String() != tt.name {
+			t.Errorf("%s: name %s, want %s", tt.in, u.String(), tt.name)
+		}
+		if du, ok := u.(*dnsUpstream); ok {
+			if du.client.Net != tt.net {
+				t.Errorf("%s: net %s, want %s", tt.in, du.client.Net, tt.net)
+			}
+			if du.client.Timeout != DefaultTimeout {
+				t.Errorf("%s: timeout %s", tt.in, du.client.Timeout)
+			}
+		} else if tt.net != "" {
+			t.Errorf("%s: got doh upstream", tt.in)
+		}
+	}
+	tu, _ := ParseUpstream("tls://dns.google", time.Second)
+	if sn := tu.(*dnsUpstream).client.TLSConfig.ServerName; sn != "dns.google" {
+		t.Errorf("tls server name %s", sn)
+	}
+	for _, s := range []string{"ftp://8.8.8.8", "tls://", "udp://%zz"} {
+		if _, err := ParseUpstream(s, 0); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+}
+
+// answer reply to r with one A record
+func answer(r *dns.Msg, ip string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A " + ip)
+	m.Answer = append(m.Answer, rr)
+	return m
+}
+
+func query(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	return m
+}
+
+func checkAnswer(t *testing.T, in *dns.Msg, q *dns.Msg, ip string) {
+	t.Helper()
+	if in.Id != q.Id {
+		t.Errorf("id %d, want %d", in.Id, q.Id)
+	}
+	if len(in.Answer) != 1 {
+		t.Fatalf("answers %v", in.Answer)
+	}
+	if a, ok := in.Answer[0].(*dns.A); !ok || a.A.String() != ip {
+		t.Errorf("answer %v, want %s", in.Answer[0], ip)
+	}
+}
+
+func TestDoHUpstream(t *testing.T) {
+	var mu sync.Mutex
+	var ids []uint16
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		q := new(dns.Msg)
+		if err := q.Unpack(b); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		ids = append(ids, q.Id)
+		mu.Unlock()
+		if q.Question[0].Name == "fail.example." {
+			http.Error(w, "fail", http.StatusBadGateway)
+			return
+		}
+		b, _ = answer(q, "192.0.2.1").Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(b) // nolint
+	}))
+	defer srv.Close()
+	u, err := ParseUpstream(srv.URL+"/dns-query", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.(*dohUpstream).client = srv.Client()
+	q := query("example.com")
+	q.Id = 4242
+	in, err := u.Exchange(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, in, q, "192.0.2.1")
+	mu.Lock()
+	if len(ids) != 1 || ids[0] != 0 {
+		t.Errorf("server got ids %v, want [0]", ids)
+	}
+	mu.Unlock()
+	if q.Id != 4242 {
+		t.Errorf("query id changed to %d", q.Id)
+	}
+	if _, err := u.Exchange(query("fail.example")); err == nil {
+		t.Error("no error on http 502")
+	}
+}
+
+// countListener counts accepted connections
+type countListener struct {
+	net.Listener
+	n int32
+}
+
+func (l *countListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.n, 1)
+	}
+	return c, err
+}
+
+func TestTLSUpstream(t *testing.T) {
+	// certificate of httptest is valid for 127.0.0.1
+	hs := httptest.NewUnstartedServer(nil)
+	hs.StartTLS()
+	cert := hs.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(hs.Certificate())
+	hs.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := &countListener{Listener: ln}
+	tl := tls.NewListener(cl, &tls.Config{Certificates: []tls.Certificate{cert}})
+	srv := &dns.Server{Listener: tl, Net: "tcp-tls", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		w.WriteMsg(answer(r, "192.0.2.2")) // nolint
+		if r.Question[0].Name == "close.example." {
+			w.Close() // nolint
+		}
+	})}
+	go srv.ActivateAndServe() // nolint
+	defer srv.Shutdown()      // nolint
+
+	u, err := ParseUpstream("tls://"+ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.(*dnsUpstream).client.TLSConfig.RootCAs = roots
+	for i := 0; i < 5; i++ {
+		q := query("example.com")
+		in, err := u.Exchange(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkAnswer(t, in, q, "192.0.2.2")
+	}
+	if n := atomic.LoadInt32(&cl.n); n != 1 {
+		t.Errorf("%d tls connections for 5 queries, want 1", n)
+	}
+
+	// connection closed by server is redialed
+	_, err = u.Exchange(query("close.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := query("example.com")
+	in, err := u.Exchange(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, in, q, "192.0.2.2")
+	if n := atomic.LoadInt32(&cl.n); n != 2 {
+		t.Errorf("%d tls connections after server close, want 2", n)
+	}
+}
+
+func TestUDPTruncatedFallback(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Skip("tcp port of udp listener is busy:", err)
+	}
+	var udp, tcp int32
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			atomic.AddInt32(&udp, 1)
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Truncated = true
+			w.WriteMsg(m) // nolint
+			return
+		}
+		atomic.AddInt32(&tcp, 1)
+		w.WriteMsg(answer(r, "192.0.2.3")) // nolint
+	})
+	us := &dns.Server{PacketConn: pc, Net: "udp", Handler: handler}
+	ts := &dns.Server{Listener: ln, Net: "tcp", Handler: handler}
+	go us.ActivateAndServe() // nolint
+	go ts.ActivateAndServe() // nolint
+	defer us.Shutdown()      // nolint
+	defer ts.Shutdown()      // nolint
+
+	u, err := ParseUpstream(pc.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := query("example.com")
+	in, err := u.Exchange(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, in, q, "192.0.2.3")
+	if n, m := atomic.LoadInt32(&udp), atomic.LoadInt32(&tcp); n != 1 || m != 1 {
+		t.Errorf("udp %d tcp %d queries, want 1 and 1", n, m)
+	}
+}